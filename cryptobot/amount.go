@@ -0,0 +1,220 @@
+package cryptobot
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Amount is a decimal amount returned by the API. Although amounts are documented as strings, some responses
+// send them as JSON numbers, so both are accepted and numbers are normalized to a plain decimal string.
+type Amount string
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	if len(data) != 0 && data[0] == '"' {
+		var s string
+		if err := unmarshal(data, &s); err != nil {
+			return err
+		}
+		*a = Amount(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := unmarshal(data, &n); err != nil {
+		return fmt.Errorf("amount should be a string or a number: %w", err)
+	}
+
+	r, err := parseAmount(n.String())
+	if err != nil {
+		return err
+	}
+
+	prec, _ := r.FloatPrec()
+	*a = Amount(r.FloatString(prec))
+
+	return nil
+}
+
+// Decimal precision of the available cryptocurrency types.
+var assetDecimals = map[CryptoAsset]int{
+	USDT: 6,
+	TON:  9,
+	BTC:  8,
+	ETH:  18,
+	LTC:  8,
+	BNB:  18,
+	TRX:  6,
+	USDC: 6,
+}
+
+// Symbols of the fiat currencies that have a widely recognized one.
+var fiatSymbols = map[CurrencyCode]string{
+	USD: "$",
+	EUR: "€",
+	RUB: "₽",
+	UAH: "₴",
+	GBP: "£",
+	CNY: "¥",
+	KZT: "₸",
+	GEL: "₾",
+	TRY: "₺",
+	THB: "฿",
+	INR: "₹",
+	BRL: "R$",
+	AZN: "₼",
+	ILS: "₪",
+}
+
+// FormatAmount formats a cryptocurrency amount for display (e.g. "5.00 USDT").
+// The amount is rounded to the precision of the asset and shown with at least two decimal places.
+// Amounts that cannot be parsed and unknown assets are formatted as is with the asset appended.
+func FormatAmount(amount string, asset CryptoAsset) string {
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return amount + " " + string(asset)
+	}
+
+	decimals, ok := assetDecimals[asset]
+	if !ok {
+		decimals = max(2, decimalPlaces(amount))
+	}
+
+	return trimZeros(r.FloatString(decimals), 2) + " " + string(asset)
+}
+
+// FormatFiat formats a fiat amount for display with two decimal places (e.g. "€4.00").
+// Currencies without a known symbol fall back to appending the currency code (e.g. "4.00 AED").
+func FormatFiat(amount string, code CurrencyCode) string {
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return amount + " " + string(code)
+	}
+
+	s := r.FloatString(2)
+
+	sym, ok := fiatSymbols[code]
+	if !ok {
+		return s + " " + string(code)
+	}
+
+	if rest, neg := strings.CutPrefix(s, "-"); neg {
+		return "-" + sym + rest
+	}
+
+	return sym + s
+}
+
+// NormalizeAmount returns the canonical form of a decimal amount, without trailing fractional zeros or an exponent
+// (e.g. "5.00" and "5" both become "5", "1e-7" becomes "0.0000001"), so amounts can be compared as strings.
+// Amounts that cannot be parsed are returned as is.
+func NormalizeAmount(amount string) string {
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return amount
+	}
+
+	prec, exact := r.FloatPrec()
+	if !exact {
+		return amount
+	}
+
+	return r.FloatString(prec)
+}
+
+// AmountFromMinorUnits converts an integer amount of minor units (e.g. cents) to a decimal amount with the given
+// number of decimal places, e.g. 500 with 2 decimals becomes "5.00".
+func AmountFromMinorUnits(units int64, decimals int) string {
+	decimals = max(decimals, 0)
+	return new(big.Rat).SetFrac(big.NewInt(units), pow10(decimals)).FloatString(decimals)
+}
+
+// AmountToMinorUnits converts a decimal amount to an integer amount of minor units, e.g. "5.00" with 2 decimals
+// becomes 500. It returns an error when the amount has more decimal places than given or does not fit in an int64.
+func AmountToMinorUnits(amount string, decimals int) (int64, error) {
+	r, err := parseAmount(amount)
+	if err != nil {
+		return 0, err
+	}
+
+	r.Mul(r, new(big.Rat).SetInt(pow10(max(decimals, 0))))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("amount %s has more than %d decimal places", amount, decimals)
+	}
+	if !r.Num().IsInt64() {
+		return 0, fmt.Errorf("amount %s is too large", amount)
+	}
+
+	return r.Num().Int64(), nil
+}
+
+// pow10 returns 10 to the power of n.
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// parseAmount parses a decimal amount string.
+func parseAmount(amount string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	return r, nil
+}
+
+// fiatDecimals is the decimal precision of fiat amounts.
+const fiatDecimals = 2
+
+// validateAmount checks that the amount is a positive number that does not round to zero at the given precision.
+func validateAmount(amount string, decimals int) error {
+	r, err := parseAmount(amount)
+	if err != nil {
+		return err
+	}
+	if r.Sign() <= 0 {
+		return fmt.Errorf("amount %s should be positive", amount)
+	}
+
+	rounded, _ := new(big.Rat).SetString(r.FloatString(decimals))
+	if rounded.Sign() == 0 {
+		return fmt.Errorf("amount %s rounds to zero at %d decimal places", amount, decimals)
+	}
+
+	return nil
+}
+
+// decimalPlaces returns the number of digits after the decimal point of a decimal string.
+func decimalPlaces(amount string) int {
+	_, frac, ok := strings.Cut(amount, ".")
+	if !ok {
+		return 0
+	}
+
+	return len(frac)
+}
+
+// trimZeros removes trailing fractional zeros from a decimal string, keeping at least keep decimal places.
+func trimZeros(s string, keep int) string {
+	whole, frac, ok := strings.Cut(s, ".")
+	if !ok {
+		if keep == 0 {
+			return s
+		}
+		return whole + "." + strings.Repeat("0", keep)
+	}
+
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) < keep {
+		frac += strings.Repeat("0", keep-len(frac))
+	}
+	if len(frac) == 0 {
+		return whole
+	}
+
+	return whole + "." + frac
+}
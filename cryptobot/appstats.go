@@ -1,7 +1,6 @@
 package cryptobot
 
 import (
-	"encoding/json"
 	"time"
 )
 
@@ -37,7 +36,7 @@ type AppStatsOptions struct {
 }
 
 func (aso AppStatsOptions) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
+	return marshal(struct {
 		StartAt string `json:"start_at"`
 		EndAt   string `json:"end_at"`
 	}{
@@ -1,12 +1,62 @@
 package cryptobot
 
+import (
+	"math/big"
+)
+
 type Balance struct {
 	// Cryptocurrency type.
 	CryptoAsset CryptoAsset `json:"currency_code"`
 
 	// Total available amount.
-	Available string `json:"available"`
+	Available Amount `json:"available"`
 
 	// Amount that is on hold and currenty unavailable.
-	OnHold string `json:"onhold"`
+	OnHold Amount `json:"onhold"`
+}
+
+// UnmarshalJSON decodes a balance. The asset is read from currency_code, falling back to asset when it is missing.
+func (b *Balance) UnmarshalJSON(data []byte) error {
+	type balance Balance
+
+	var tmp struct {
+		balance
+		Asset CryptoAsset `json:"asset"`
+	}
+
+	if err := unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	*b = Balance(tmp.balance)
+	if len(b.CryptoAsset) == 0 {
+		b.CryptoAsset = tmp.Asset
+	}
+
+	return nil
+}
+
+// AvailableAmount returns the available amount as a decimal number.
+func (b Balance) AvailableAmount() (*big.Rat, error) {
+	return parseAmount(string(b.Available))
+}
+
+// OnHoldAmount returns the amount on hold as a decimal number.
+func (b Balance) OnHoldAmount() (*big.Rat, error) {
+	return parseAmount(string(b.OnHold))
+}
+
+// Total returns the sum of the available and on hold amounts, keeping the precision of the more precise of the two.
+func (b Balance) Total() (string, error) {
+	av, err := b.AvailableAmount()
+	if err != nil {
+		return "", err
+	}
+
+	oh, err := b.OnHoldAmount()
+	if err != nil {
+		return "", err
+	}
+
+	return new(big.Rat).Add(av, oh).FloatString(max(decimalPlaces(string(b.Available)), decimalPlaces(string(b.OnHold)))), nil
 }
@@ -1,8 +1,8 @@
 package cryptobot
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -11,7 +11,7 @@ type CheckStatus string
 
 const (
 	CheckActive    CheckStatus = "active"
-	CheckActivated             = "activated"
+	CheckActivated CheckStatus = "activated"
 )
 
 type Check struct {
@@ -25,7 +25,7 @@ type Check struct {
 	CryptoAsset CryptoAsset `json:"asset"`
 
 	// Amount of the check.
-	Amount string `json:"amount"`
+	Amount Amount `json:"amount"`
 
 	// URL for the user to activate the check.
 	BotCheckURL string `json:"bot_check_url"`
@@ -40,6 +40,22 @@ type Check struct {
 	ActivatedAt string `json:"activated_at"`
 }
 
+// String formats the check with its hash and URL redacted, since anyone with the URL can activate the check.
+func (c Check) String() string {
+	type check Check
+
+	r := check(c)
+	r.Hash = redact(r.Hash)
+	r.BotCheckURL = redactURL(r.BotCheckURL)
+
+	return fmt.Sprintf("%+v", r)
+}
+
+// HashFromURL extracts the check hash from BotCheckURL.
+func (c Check) HashFromURL() (string, error) {
+	return hashFromURL(c.BotCheckURL)
+}
+
 type NewCheck struct {
 	// Type of cryptocurrency.
 	CryptoAsset CryptoAsset `json:"asset"`
@@ -47,13 +63,29 @@ type NewCheck struct {
 	// Amount of the check.
 	Amount string `json:"amount"`
 
-	// Optional. Telegram id of the user who will be able to activate the check.
+	// Optional. Telegram id of the user who will be able to activate the check. Cannot be set together with PinToUsername.
 	PinToUserID int64 `json:"pin_to_user_id,omitempty"`
 
-	// Optional. Telegram user name who will be able to activate the check.
+	// Optional. Telegram user name who will be able to activate the check. Cannot be set together with PinToUserID.
 	PinToUsername string `json:"pin_to_username,omitempty"`
 }
 
+type tempNewCheck struct {
+	CryptoAsset   CryptoAsset `json:"asset"`
+	Amount        string      `json:"amount"`
+	PinToUserID   int64       `json:"pin_to_user_id,omitempty"`
+	PinToUsername string      `json:"pin_to_username,omitempty"`
+}
+
+// MarshalJSON encodes the check with at most one of the pin fields.
+func (nc NewCheck) MarshalJSON() ([]byte, error) {
+	if nc.PinToUserID != 0 && len(nc.PinToUsername) != 0 {
+		return nil, errors.New("PinToUserID and PinToUsername cannot both be set")
+	}
+
+	return marshal(tempNewCheck(nc))
+}
+
 type CheckOptions struct {
 	// Optional. Type of cryptocurrency to search by.
 	CryptoAsset CryptoAsset `json:"asset,omitempty"`
@@ -80,13 +112,13 @@ type tempCheckOps struct {
 }
 
 func (co CheckOptions) MarshalJSON() ([]byte, error) {
-	ids := make([]string, len(co.CheckIDs))
+	ids := make([]string, 0, len(co.CheckIDs))
 
 	for _, id := range co.CheckIDs {
 		ids = append(ids, strconv.FormatInt(id, 10))
 	}
 
-	return json.Marshal(&tempCheckOps{
+	return marshal(&tempCheckOps{
 		CryptoAsset: string(co.CryptoAsset),
 		CheckIDs:    strings.Join(ids, ","),
 		Status:      string(co.Status),
@@ -104,6 +136,9 @@ func validateNewCheck(nc NewCheck) error {
 	if len(nc.Amount) == 0 {
 		errs = append(errs, errors.New("Amount cannot be empty"))
 	}
+	if nc.PinToUserID != 0 && len(nc.PinToUsername) != 0 {
+		errs = append(errs, errors.New("PinToUserID and PinToUsername cannot both be set"))
+	}
 
 	if len(errs) == 0 {
 		return nil
@@ -2,14 +2,25 @@ package cryptobot
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"log/slog"
+	"maps"
+	"math/big"
 	"net/http"
 	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -17,14 +28,49 @@ const (
 	Testnet = "https://testnet-pay.crypt.bot/api" // [CryptoTestnetBot](http://t.me/CryptoTestnetBot)
 )
 
+// JSON encoding functions used by the client. They default to encoding/json and can be replaced with SetJSONCodec.
+// Every request body, response and webhook update, including the MarshalJSON and UnmarshalJSON methods of the
+// package types and Invoice.SetPayload and Invoice.Payload, goes through them. Only the syntax check and
+// compaction of responses (json.Valid and json.Compact) always use encoding/json.
+var (
+	marshal   = json.Marshal
+	unmarshal = json.Unmarshal
+)
+
+// SetJSONCodec replaces the functions the client uses to encode requests and decode responses,
+// e.g. with a faster encoding/json compatible library. The functions are stored in package variables without
+// synchronization, so SetJSONCodec must only be called during program initialization, before any client is used.
+func SetJSONCodec(m func(v any) ([]byte, error), u func(data []byte, v any) error) {
+	marshal = m
+	unmarshal = u
+}
+
 type resultConstraint interface {
-	json.RawMessage | Invoice | Check | Transfer | AppStats | []Balance | []ExchangeRate | bool | struct {
-		Items []Invoice `json:"items"`
-	} | struct {
-		Items []Check `json:"items"`
-	} | struct {
-		Items []Transfer `json:"items"`
+	json.RawMessage | Invoice | Check | Transfer | AppStats | []Balance | []ExchangeRate | []Currency | bool | items[Invoice] | items[Check] | items[Transfer] | items[json.RawMessage]
+}
+
+// items is a list result. It is decoded from the documented {"items": [...]} object and falls back to a bare array.
+type items[T Invoice | Check | Transfer | json.RawMessage] []T
+
+func (it *items[T]) UnmarshalJSON(data []byte) error {
+	var obj struct {
+		Items []T `json:"items"`
+	}
+
+	if err := unmarshal(data, &obj); err == nil {
+		*it = obj.Items
+		return nil
+	}
+
+	var arr []T
+
+	if err := unmarshal(data, &arr); err != nil {
+		return err
 	}
+
+	*it = arr
+
+	return nil
 }
 
 type response[T resultConstraint] struct {
@@ -33,499 +79,2278 @@ type response[T resultConstraint] struct {
 	Result T               `json:"result"`
 }
 
+// defaultCount is the number of items the API returns by list methods when no count is given.
+const defaultCount = 100
+
+// DefaultRetryBackoff is the delay before the first retry used when none is configured.
+const DefaultRetryBackoff = 500 * time.Millisecond
+
+// MaxRetryBackoff is the longest delay between retries. The doubled backoff stops growing once it reaches it.
+const MaxRetryBackoff = time.Minute
+
+// idempotentMethods are the API methods that can be retried without side effects.
+var idempotentMethods = map[string]bool{
+	"getMe":            true,
+	"getInvoices":      true,
+	"getChecks":        true,
+	"getTransfers":     true,
+	"getBalance":       true,
+	"getExchangeRates": true,
+	"getCurrencies":    true,
+	"getStats":         true,
+	"deleteInvoice":    true,
+	"deleteCheck":      true,
+	"transfer":         true,
+}
+
+// retryable reports whether a failed attempt may succeed when repeated.
+func retryable(ctx context.Context, status int, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if status >= 500 || status == http.StatusTooManyRequests {
+		return true
+	}
+	var terr *transportError
+	return errors.As(err, &terr)
+}
+
+// transportError marks a failure of the http client to get a response. Unlike failures to build the request,
+// which would fail the same way every time, it is worth retrying.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// MaxIDsPerRequest is the maximum number of ids sent in a single list request by the batch helpers.
+const MaxIDsPerRequest = 100
+
+// DefaultMaxResponseBytes is the maximum size of a response body used when none is configured.
+const DefaultMaxResponseBytes = 10 << 20
+
+// DeleteConcurrency is the maximum number of concurrent requests issued by DeleteExpiredInvoices.
+const DeleteConcurrency = 5
+
+// AppStatsConcurrency is the maximum number of concurrent requests issued by GetAppStatsRange.
+const AppStatsConcurrency = 5
+
+// Encoding is the format of request bodies.
+type Encoding string
+
+const (
+	// EncodingJSON sends parameters as a JSON body using the HTTP method of the API method.
+	EncodingJSON Encoding = "json"
+	// EncodingForm sends parameters as an application/x-www-form-urlencoded body. Requests with parameters
+	// are always sent via POST, which avoids GET requests with a body.
+	EncodingForm Encoding = "form"
+)
+
+// DefaultTimeout is the request timeout used when no http client is provided.
+const DefaultTimeout = 30 * time.Second
+
 type Config struct {
 	// Cryptobot API token
 	Token string
 	// Mainnet or Testnet
 	Endpoint string
 	Client   *http.Client
+	// Optional. Timeout of the http client created when Client is nil, so it cannot be combined with Client, whose own
+	// timeout applies instead. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Optional. Maximum size of a response body in bytes. Defaults to DefaultMaxResponseBytes.
+	MaxResponseBytes int64
+	// Optional. How long GetExchangeRates reuses previously fetched rates. Zero disables caching.
+	RatesCacheTTL time.Duration
+	// Optional. Decode the items of GetInvoices, GetChecks and GetTransfers one by one, so malformed items are
+	// skipped and reported in a *PartialResultError returned along with the decoded ones.
+	PartialResults bool
+	// Optional. Timeouts of individual API methods keyed by their name (e.g. "getMe" or "getInvoices").
+	// Methods without one are only limited by the timeout of the http client.
+	MethodTimeouts map[string]time.Duration
+	// Optional. Value of the Accept-Language header sent with every request (e.g. "de" or "en-US,en;q=0.8"),
+	// so localized strings are returned in the given language. No header is sent when empty.
+	AcceptLanguage string
+	// Optional. Currency type of the invoices created without one.
+	DefaultCurrencyType CurrencyType
+	// Optional. Asset of the crypto invoices created without one.
+	DefaultCryptoAsset CryptoAsset
+	// Optional. How many times a failed request is retried when it fails with a transport error, a 5xx status or
+	// a 429 status. Only idempotent methods are retried (reads, deletes and transfers, which are deduplicated by
+	// their SpendID), since retrying createInvoice or createCheck after an ambiguous failure could create duplicates.
+	MaxRetries int
+	// Optional. Delay before the first retry, doubled on every following one up to MaxRetryBackoff.
+	// Defaults to DefaultRetryBackoff.
+	RetryBackoff time.Duration
+	// Optional. Also retry the methods that are not idempotent.
+	RetryUnsafe bool
+	// Optional. Format of request bodies. Defaults to EncodingJSON.
+	Encoding Encoding
+	// Optional. Number of items requested by GetInvoices, GetChecks and GetTransfers when the options leave Count
+	// at zero. Values between 1-1000 are accepted. When unset, the API default of 100 applies.
+	DefaultCount int64
+	// Optional. Fee buffers added to the amount of a transfer by CanAfford, keyed by asset (e.g. {TON: "0.01"}).
+	TransferFeeBuffers map[CryptoAsset]string
+	// Optional. Detect the network of the token instead of setting Endpoint, which has to be left empty. NewClient
+	// probes getMe on Mainnet and then on Testnet and uses the endpoint that accepts the token, failing when neither does.
+	AutoNetwork bool
+	// Optional. Round amounts with more decimal places than their asset supports when creating invoices, checks and
+	// transfers, instead of rejecting them.
+	RoundAmounts bool
+	// Optional. Ceiling of the USD value of created invoices (e.g. "10000"), guarding against invoices with a mistakenly
+	// huge amount. The amount is converted with the up-to-date exchange rates, and the check is skipped when they cannot
+	// be fetched or have no rate for the invoice currency. Empty or zero disables the ceiling.
+	MaxInvoiceAmountUSD string
+	// Optional. Called with every outbound request and its body right before it is sent, e.g. to add a signature
+	// header for an egress proxy. It is called again for every retry. The body is nil for bodiless requests and
+	// must not be modified.
+	OutboundSigner func(req *http.Request, body []byte)
+	// Optional. Writer the raw JSON response body of every request is written to, one per line, e.g. to pipe them into
+	// jq. Responses are decoded as usual. Only response bodies are written, so the token is never included.
+	Tap io.Writer
+	// Optional. Logger every request attempt is logged to, at debug level when it succeeds and at warn level when it
+	// fails or gets an error status. Entries include the correlation id of the request context (see WithCorrelationID),
+	// which is also added to the request errors. Nothing is logged when nil.
+	Logger *slog.Logger
+	// Optional. Maximum number of idle connections kept open to the API by the http client created when Client is nil,
+	// so it cannot be combined with Client. Defaults to the http.DefaultTransport setting.
+	MaxIdleConnsPerHost int
+	// Optional. URL of an http(s) or socks5 proxy all requests are sent through (e.g. "socks5://127.0.0.1:1080").
+	// It is used by the http client created when Client is nil, so it cannot be combined with Client.
+	ProxyURL string
+}
+
+// Clone returns a copy of the config that can be modified without affecting the original. The MethodTimeouts and
+// TransferFeeBuffers maps are copied, while the http client is shared on purpose, so clients built from clones reuse
+// its connections. Set Client on the clone to give it its own.
+func (cf Config) Clone() Config {
+	cf.MethodTimeouts = maps.Clone(cf.MethodTimeouts)
+	cf.TransferFeeBuffers = maps.Clone(cf.TransferFeeBuffers)
+	return cf
 }
 
+// Client is a Crypto Pay API client. Whenever the API responds with a null result,
+// methods return the zero value of their result type (e.g. false or a nil slice) without an error.
+// Returned errors are prefixed with the method name (e.g. "CreateInvoice: ...") and wrap the underlying
+// error, so APIError and ResponseError can still be retrieved with errors.As.
 type Client interface {
 	// HandleUpdate is meant for proccessing webhook update messages. It includes verification of update message integrety.
 	// You are free to implement your own handler. This is just a minimal implementation.
 	HandleUpdate(r *http.Request) (Update, error)
 
+	// HandleUpdateVerified is like HandleUpdate, but also fetches the invoice of the update and rejects the update
+	// unless the API confirms that the invoice exists and is paid. It guards against forged or replayed updates.
+	HandleUpdateVerified(ctx context.Context, r *http.Request) (Update, error)
+
 	// GetMe returns basic application information. The return of the getMe API method is not documented.
 	// To mitigate any potential issues GetMe returns raw json.
 	GetMe() (json.RawMessage, error)
 
+	// VerifyNetwork calls getMe and checks that the app belongs to the network of the configured endpoint, so that
+	// e.g. a mainnet token used with the Testnet endpoint is caught early. A mismatch is reported with an error matching
+	// ErrNetworkMismatch. The network can only be told for the Mainnet and Testnet endpoints.
+	VerifyNetwork() error
+
+	// AppID returns the id of the app the token belongs to, which the token carries before the colon
+	// (e.g. 12345 for "12345:AAf..."). It is parsed from the configured token without an API call.
+	AppID() (int64, error)
+
+	// Do calls an API method by name (e.g. "getInvoices") with params encoded like the body of any other request,
+	// and returns the response undecoded. A nil params sends no body. The error is only set when no response
+	// could be read (e.g. a transport error or a body that is not JSON), so a response with ok set to false
+	// is returned with a nil error and can be told apart by its Ok field.
+	Do(ctx context.Context, method string, params any) (RawResponse, error)
+
+	// WithClient returns a copy of the client that sends requests using the given http client.
+	// The original client is left untouched.
+	WithClient(c *http.Client) Client
+
+	// Ping confirms that the API is reachable and the token is valid. It returns nil on success,
+	// an error matching ErrUnauthorized when the token is rejected and the transport error otherwise.
+	Ping(ctx context.Context) error
+
+	// Shutdown cancels the in-flight requests and waits for them to return until ctx is done, in which case
+	// it returns the context error. Requests made after Shutdown fail with ErrClientClosed. Copies returned
+	// by WithClient share the shutdown state.
+	Shutdown(ctx context.Context) error
+
 	// CreateInvoice takes in a new invoice and returns the invoice on success.
 	CreateInvoice(in NewInvoice) (Invoice, error)
 
+	// NewInvoiceFromMinorUnits returns a new crypto invoice for the given amount of minor units of the asset, using the
+	// number of decimal places of the asset reported by getCurrencies (e.g. 500 units of an asset with 6 decimals is
+	// "0.000500"). The invoice is not created, so the rest of its fields can be set before CreateInvoice.
+	NewInvoiceFromMinorUnits(asset CryptoAsset, units int64) (NewInvoice, error)
+
+	// RenewInvoice creates a new invoice with the same parameters (amount, description, assets, expiration time, payload etc.)
+	// as the given one. It returns an error unless the invoice has expired, so active and paid invoices are never renewed.
+	RenewInvoice(old Invoice) (Invoice, error)
+
 	// DeleteInvoice takes in the id of the invoice you want to delete. The bool indicates whether the deletion was successful.
 	DeleteInvoice(id int64) (bool, error)
 
+	// DeleteInvoiceResult is like DeleteInvoice, but returns the raw result, and reports the name of the API error
+	// when the deletion is refused (e.g. INVOICE_NOT_FOUND) instead of returning it, so a refusal can be told
+	// apart from a false result and from a failed request.
+	DeleteInvoiceResult(id int64) (DeleteResult, error)
+
+	// DeleteInvoiceIdempotent is like DeleteInvoice, but treats an invoice that was not found (e.g. already deleted
+	// by a previous attempt) as successfully deleted. It is meant for retrying deletions.
+	DeleteInvoiceIdempotent(id int64) (bool, error)
+
 	// GetInvoices takes in invoice search options and returns found invoices on success.
 	GetInvoices(inop InvoiceOptions) ([]Invoice, error)
 
+	// GetInvoicesPage is like GetInvoices, but also reports whether more invoices may follow, i.e. whether the page
+	// is full. A full last page still reports true, so the next page may turn out to be empty.
+	GetInvoicesPage(inop InvoiceOptions) (ins []Invoice, hasMore bool, err error)
+
+	// GetInvoicesByIDs fetches invoices by id, splitting the ids into batches of at most MaxIDsPerRequest.
+	// Duplicate ids are fetched once.
+	GetInvoicesByIDs(ids []int64) ([]Invoice, error)
+
+	// PollInvoices fetches the current state of the invoices by id, in as few requests as GetInvoicesByIDs,
+	// and returns them keyed by id. Invoices that were not found are left out of the map.
+	PollInvoices(ids []int64) (map[int64]Invoice, error)
+
+	// GetInvoicesByPayload returns invoices whose payload exactly matches the given one. The API cannot filter by payload,
+	// so this pages through every invoice matched by base and filters them client-side, issuing one request per page.
+	// Narrow the scan with the base filters (status, asset, fiat, ids) and Offset; base.Count sets the page size and defaults to 1000.
+	GetInvoicesByPayload(payload string, base InvoiceOptions) ([]Invoice, error)
+
+	// FilterInvoices pages through the invoices matched by opts, starting at opts.Offset with pages of opts.Count
+	// invoices, and yields the ones pred reports true for. A failed request is yielded as an error and ends the sequence.
+	// Pages are fetched lazily, so breaking out of the loop stops further requests.
+	FilterInvoices(opts InvoiceOptions, pred func(Invoice) bool) iter.Seq2[Invoice, error]
+
+	// DeleteExpiredInvoices deletes all expired invoices and returns the number of deleted invoices.
+	// Invoices are deleted concurrently, with at most DeleteConcurrency requests in flight. Once ctx is done,
+	// no more deletions are started and the ones in flight are cancelled; the invoices deleted so far are
+	// counted and the context error is returned.
+	DeleteExpiredInvoices(ctx context.Context) (int, error)
+
 	// CreateCheck takes in a new check and returns the check on success.
 	CreateCheck(nc NewCheck) (Check, error)
 
+	// CreateChecks creates n checks of the same asset and amount, at most concurrency at a time. After the first
+	// failure no more checks are created, and the checks created so far are returned along with the error.
+	CreateChecks(asset CryptoAsset, amount string, n int, concurrency int) ([]Check, error)
+
 	// DeleteCheck takes in the id of the check you want to delete. The bool indicates whether the deletion was successful.
 	DeleteCheck(id int64) (bool, error)
 
+	// DeleteCheckResult is like DeleteCheck, but returns the raw result, and reports the name of the API error
+	// when the deletion is refused (e.g. CHECK_NOT_FOUND) instead of returning it.
+	DeleteCheckResult(id int64) (DeleteResult, error)
+
 	// GetChecks takes in check search options and returns found checks on success.
 	GetChecks(ckops CheckOptions) ([]Check, error)
 
-	// CreateTransfer takes in a new transfer and returns the transfer on success.
+	// CreateTransfer takes in a new transfer and returns the transfer on success. Returned errors include
+	// the beginning of the SpendID of the transfer, which is redacted like in Transfer.String.
 	CreateTransfer(nt NewTransfer) (Transfer, error)
 
+	// CreateTransfers sends a batch of transfers one by one and returns the completed ones. The whole batch is
+	// validated before any request is sent, including that no two transfers share a SpendID. Sending stops at
+	// the first failed transfer.
+	CreateTransfers(nts []NewTransfer) ([]Transfer, error)
+
 	// GetTransfers takes in transfer search options and returns found transfers on success.
 	GetTransfers(trops TransferOptions) ([]Transfer, error)
 
+	// ConfirmTransfer looks up the transfer by its spend id and returns it if it has completed.
+	// Otherwise it returns an *UnconfirmedTransferError.
+	ConfirmTransfer(spendID string) (Transfer, error)
+
+	// ReconcileTransfers looks up the expected transfers by their spend ids and returns the ones
+	// that have no completed transfer in the transfer history. One request is issued per transfer.
+	ReconcileTransfers(expected []NewTransfer) (missing []NewTransfer, err error)
+
 	// GetBalance return the current application balance.
 	GetBalance() ([]Balance, error)
 
+	// GetBalanceMap returns the current application balance indexed by asset. If an asset is listed
+	// more than once, the last balance is kept.
+	GetBalanceMap() (map[CryptoAsset]Balance, error)
+
+	// CanAfford reports whether the available balance of the asset covers the transfer amount plus the fee buffer
+	// configured for the asset, so a transfer can be checked before it fails with NOT_ENOUGH_COINS. It does not
+	// reserve the balance, so a concurrent transfer can still spend it.
+	CanAfford(nt NewTransfer) (bool, error)
+
 	// GetExchangeRates return exchange rates of supported currencies.
 	GetExchangeRates() ([]ExchangeRate, error)
 
+	// StreamExchangeRates fetches the exchange rates right away and then on every interval, and sends them on the
+	// returned rates channel. Failed fetches are sent on the error channel without stopping the stream; an error is
+	// dropped while the previous one is still unread. Both channels are closed once ctx is done. Rates are cached
+	// like in GetExchangeRates, so RatesCacheTTL should be shorter than the interval. The interval should be positive.
+	StreamExchangeRates(ctx context.Context, interval time.Duration) (<-chan []ExchangeRate, <-chan error)
+
+	// GetExchangeRatesSnapshot is like GetExchangeRates, but also returns the local time the rates were fetched at,
+	// which is the time of the original fetch for cached rates.
+	GetExchangeRatesSnapshot() (RatesSnapshot, error)
+
+	// GetValidExchangeRates is like GetExchangeRates, but leaves out the rates that are not up-to-date.
+	GetValidExchangeRates() ([]ExchangeRate, error)
+
+	// EstimateFiatValue converts a cryptocurrency amount to the fiat currency using the current up-to-date rate
+	// and returns it formatted as an approximation (e.g. "≈ €4.50"). It returns an error when there is no such rate.
+	EstimateFiatValue(amount string, asset CryptoAsset, fiat CurrencyCode) (string, error)
+
+	// GetCurrencies returns the currencies supported by the API.
+	GetCurrencies() ([]Currency, error)
+
 	// GetAppStats takes in application statistics search options and return found application statistics on success.
 	GetAppStats(asops AppStatsOptions) (AppStats, error)
+
+	// GetAppStatsMulti concurrently fetches application statistics for each of the windows ending now
+	// (e.g. the last 24 hours) and returns them keyed by window.
+	GetAppStatsMulti(windows []time.Duration) (map[time.Duration]AppStats, error)
+
+	// GetAppStatsRange splits the range from start to end into consecutive windows of the given length, the last
+	// one ending at end, and returns the application statistics of each window in order. Windows are fetched
+	// concurrently, with at most AppStatsConcurrency requests in flight.
+	GetAppStatsRange(start, end time.Time, window time.Duration) ([]AppStats, error)
+}
+
+// ContextClient is a Client whose API method wrappers and lookups can also be called with a context, which can
+// cancel their requests and carry a correlation id (see WithCorrelationID). Each XxxContext method is like the
+// Xxx method of Client. It is kept apart from Client so that existing implementations of Client keep compiling.
+// The clients returned by NewClient and Client.WithClient implement it, e.g. cb.(cryptobot.ContextClient).
+type ContextClient interface {
+	Client
+
+	GetMeContext(ctx context.Context) (json.RawMessage, error)
+	CreateInvoiceContext(ctx context.Context, in NewInvoice) (Invoice, error)
+	DeleteInvoiceContext(ctx context.Context, id int64) (bool, error)
+	GetInvoicesContext(ctx context.Context, inop InvoiceOptions) ([]Invoice, error)
+	GetInvoicesByIDsContext(ctx context.Context, ids []int64) ([]Invoice, error)
+	CreateCheckContext(ctx context.Context, nc NewCheck) (Check, error)
+	DeleteCheckContext(ctx context.Context, id int64) (bool, error)
+	GetChecksContext(ctx context.Context, ckops CheckOptions) ([]Check, error)
+	CreateTransferContext(ctx context.Context, nt NewTransfer) (Transfer, error)
+	GetTransfersContext(ctx context.Context, trops TransferOptions) ([]Transfer, error)
+	ConfirmTransferContext(ctx context.Context, spendID string) (Transfer, error)
+	GetBalanceContext(ctx context.Context) ([]Balance, error)
+	GetBalanceMapContext(ctx context.Context) (map[CryptoAsset]Balance, error)
+	GetExchangeRatesContext(ctx context.Context) ([]ExchangeRate, error)
+	GetValidExchangeRatesContext(ctx context.Context) ([]ExchangeRate, error)
+	GetCurrenciesContext(ctx context.Context) ([]Currency, error)
+	GetAppStatsContext(ctx context.Context, asops AppStatsOptions) (AppStats, error)
 }
 
 type cryptobot struct {
 	token    string
 	client   *http.Client
 	endpoint string
+	maxBytes int64
+	ratesTTL time.Duration
+	partial  bool
+	timeouts map[string]time.Duration
+	language string
+	defType  CurrencyType
+	defAsset CryptoAsset
+	retries  int
+	backoff  time.Duration
+	retryAll bool
+	encoding Encoding
+	defCount int64
+	buffers  map[CryptoAsset]string
+	round    bool
+	maxUSD   string
+	signer   func(req *http.Request, body []byte)
+	tap      *tap
+	logger   *slog.Logger
+	rates    *ratesCache
+	decimals *precisionCache
+	life     *lifecycle
+	// now is used to read the current time, so it can be replaced in tests.
+	now func() time.Time
+	// after is used to wait between retries, so the backoff can be skipped in tests.
+	after func(d time.Duration) <-chan time.Time
+}
+
+// ValidateConfig reports all the problems with the config at once. It is called by NewClient,
+// but can be used on its own to fail fast during startup. Besides Mainnet and Testnet, any absolute
+// http(s) endpoint is accepted (e.g. a proxy or a test server).
+func ValidateConfig(cf Config) error {
+	var errs []error
+
+	if len(cf.Token) == 0 {
+		errs = append(errs, errors.New("no token was provided for crypto bot"))
+	}
+	if cf.AutoNetwork {
+		if len(cf.Endpoint) != 0 {
+			errs = append(errs, errors.New("endpoint cannot be set together with auto network"))
+		}
+	} else if len(cf.Endpoint) == 0 {
+		errs = append(errs, errors.New("no endpoint was provided for crypto bot"))
+	} else if u, err := url.Parse(cf.Endpoint); err != nil {
+		errs = append(errs, fmt.Errorf("endpoint is not a valid url: %w", err))
+	} else if (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		errs = append(errs, fmt.Errorf("endpoint %q is not an absolute http(s) url", cf.Endpoint))
+	} else if len(u.RawQuery) != 0 || len(u.Fragment) != 0 || u.ForceQuery {
+		errs = append(errs, fmt.Errorf("endpoint %q cannot have a query string or a fragment", cf.Endpoint))
+	}
+	if cf.Timeout < 0 {
+		errs = append(errs, errors.New("timeout cannot be negative"))
+	} else if cf.Timeout != 0 && cf.Client != nil {
+		errs = append(errs, errors.New("timeout cannot be set together with a client"))
+	}
+	if cf.MaxResponseBytes < 0 {
+		errs = append(errs, errors.New("max response bytes cannot be negative"))
+	}
+	if cf.RatesCacheTTL < 0 {
+		errs = append(errs, errors.New("rates cache ttl cannot be negative"))
+	}
+	if len(cf.DefaultCurrencyType) != 0 && cf.DefaultCurrencyType != Crypto && cf.DefaultCurrencyType != Fiat {
+		errs = append(errs, fmt.Errorf("default currency type %q is not supported", cf.DefaultCurrencyType))
+	}
+	if cf.MaxRetries < 0 {
+		errs = append(errs, errors.New("max retries cannot be negative"))
+	}
+	if cf.RetryBackoff < 0 {
+		errs = append(errs, errors.New("retry backoff cannot be negative"))
+	} else if cf.RetryBackoff > MaxRetryBackoff {
+		errs = append(errs, fmt.Errorf("retry backoff cannot be greater than %s", MaxRetryBackoff))
+	}
+	if len(cf.Encoding) != 0 && cf.Encoding != EncodingJSON && cf.Encoding != EncodingForm {
+		errs = append(errs, fmt.Errorf("encoding %q is not supported", cf.Encoding))
+	}
+	if cf.DefaultCount < 0 || cf.DefaultCount > 1000 {
+		errs = append(errs, errors.New("default count needs to be within 1-1000 record range"))
+	}
+	if len(cf.ProxyURL) != 0 {
+		if u, err := url.Parse(cf.ProxyURL); err != nil {
+			errs = append(errs, fmt.Errorf("proxy url is not a valid url: %w", err))
+		} else if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || len(u.Host) == 0 {
+			errs = append(errs, fmt.Errorf("proxy url %q is not an absolute http(s) or socks5 url", cf.ProxyURL))
+		}
+		if cf.Client != nil {
+			errs = append(errs, errors.New("proxy url cannot be set together with a client"))
+		}
+	}
+	if cf.MaxIdleConnsPerHost < 0 {
+		errs = append(errs, errors.New("max idle connections per host cannot be negative"))
+	} else if cf.MaxIdleConnsPerHost != 0 && cf.Client != nil {
+		errs = append(errs, errors.New("max idle connections per host cannot be set together with a client"))
+	}
+	if len(cf.MaxInvoiceAmountUSD) != 0 {
+		if r, err := parseAmount(cf.MaxInvoiceAmountUSD); err != nil || r.Sign() < 0 {
+			errs = append(errs, fmt.Errorf("max invoice amount %q should be a non-negative amount", cf.MaxInvoiceAmountUSD))
+		}
+	}
+	for a, fee := range cf.TransferFeeBuffers {
+		if r, err := parseAmount(fee); err != nil || r.Sign() < 0 {
+			errs = append(errs, fmt.Errorf("fee buffer %q of %s should be a non-negative amount", fee, a))
+		}
+	}
+	for m, d := range cf.MethodTimeouts {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("timeout of method %s should be positive", m))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errors.Join(errs...)
 }
 
 // NewClinet creates a new crypto bot client. There are two endpoints: Testnet and Mainnet.
 // Testnet is used for testing and Mainnet for production. You need a different token for each of the networks.
-// It uses the default http client if none is provided.
+// If no http client is provided, a new one is created with Timeout (or DefaultTimeout when unset), ProxyURL
+// and MaxIdleConnsPerHost.
 func NewClient(cf Config) (Client, error) {
-	if len(cf.Token) == 0 {
-		return nil, errors.New("no token was provided for crypto bot")
-	}
-	if len(cf.Endpoint) == 0 {
-		return nil, errors.New("no endpoint was provided for crypto bot")
+	if err := ValidateConfig(cf); err != nil {
+		return nil, err
 	}
 	if cf.Client == nil {
-		cf.Client = http.DefaultClient
+		if cf.Timeout == 0 {
+			cf.Timeout = DefaultTimeout
+		}
+		// Each client gets its own instance so http.DefaultClient is never shared or mutated.
+		cf.Client = &http.Client{Timeout: cf.Timeout}
+		if len(cf.ProxyURL) != 0 || cf.MaxIdleConnsPerHost != 0 {
+			tr := http.DefaultTransport.(*http.Transport).Clone()
+			if len(cf.ProxyURL) != 0 {
+				proxy, _ := url.Parse(cf.ProxyURL)
+				tr.Proxy = http.ProxyURL(proxy)
+			}
+			if cf.MaxIdleConnsPerHost != 0 {
+				tr.MaxIdleConnsPerHost = cf.MaxIdleConnsPerHost
+			}
+			cf.Client.Transport = tr
+		}
+	}
+	if cf.MaxResponseBytes == 0 {
+		cf.MaxResponseBytes = DefaultMaxResponseBytes
+	}
+	if cf.RetryBackoff == 0 {
+		cf.RetryBackoff = DefaultRetryBackoff
+	}
+	if len(cf.Encoding) == 0 {
+		cf.Encoding = EncodingJSON
+	}
+	// Trailing slashes are dropped so method paths are always joined the same way.
+	cf.Endpoint = strings.TrimRight(cf.Endpoint, "/")
+
+	cb := &cryptobot{
+		token:    cf.Token,
+		endpoint: cf.Endpoint,
+		client:   cf.Client,
+		maxBytes: cf.MaxResponseBytes,
+		ratesTTL: cf.RatesCacheTTL,
+		partial:  cf.PartialResults,
+		timeouts: maps.Clone(cf.MethodTimeouts),
+		language: cf.AcceptLanguage,
+		defType:  cf.DefaultCurrencyType,
+		defAsset: cf.DefaultCryptoAsset,
+		retries:  cf.MaxRetries,
+		backoff:  cf.RetryBackoff,
+		retryAll: cf.RetryUnsafe,
+		encoding: cf.Encoding,
+		defCount: cf.DefaultCount,
+		buffers:  maps.Clone(cf.TransferFeeBuffers),
+		round:    cf.RoundAmounts,
+		maxUSD:   cf.MaxInvoiceAmountUSD,
+		logger:   cf.Logger,
+		signer:   cf.OutboundSigner,
+		rates:    &ratesCache{},
+		decimals: &precisionCache{},
+		life:     newLifecycle(),
+		now:      time.Now,
+		after:    time.After,
+	}
+	if cf.Tap != nil {
+		cb.tap = &tap{w: cf.Tap}
+	}
+
+	if cf.AutoNetwork {
+		if err := cb.detectNetwork(); err != nil {
+			return nil, err
+		}
+	}
+
+	return cb, nil
+}
+
+// detectNetwork sets the endpoint to the first network whose getMe accepts the token.
+func (cb *cryptobot) detectNetwork() error {
+	for _, endpoint := range []string{Mainnet, Testnet} {
+		cb.endpoint = endpoint
+
+		_, err := cb.getMe(context.Background())
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrUnauthorized) {
+			return fmt.Errorf("failed to detect the network: %w", err)
+		}
 	}
 
-	return &cryptobot{token: cf.Token, endpoint: cf.Endpoint, client: cf.Client}, nil
+	return errors.New("failed to detect the network: the token was rejected by both mainnet and testnet")
 }
 
-func (cb cryptobot) makeRequest(method, url string, r io.Reader) ([]byte, error) {
-	req, err := http.NewRequest(method, url, r)
-	if err != nil {
-		return nil, err
+func (cb cryptobot) WithClient(c *http.Client) Client {
+	if c != nil {
+		cb.client = c
 	}
+	return &cb
+}
 
-	req.Header.Set("Crypto-Pay-API-Token", cb.token)
-	req.Header.Set("Content-Type", "application/json")
+func (cb cryptobot) Shutdown(ctx context.Context) error {
+	return cb.life.shutdown(ctx)
+}
 
-	res, err := cb.client.Do(req)
-	if err != nil {
-		return nil, err
+func (cb cryptobot) makeRequest(ctx context.Context, method, url string, data []byte) (_ []byte, err error) {
+	if id, ok := CorrelationIDFromContext(ctx); ok {
+		defer wrapError(fmt.Sprintf("request(correlation_id=%q)", id), &err)
 	}
-	defer res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	ctx, done, err := cb.life.begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer done()
 
-	return body, nil
-}
+	name := path.Base(url)
 
-func (cb cryptobot) HandleUpdate(r *http.Request) (Update, error) {
-	sig := r.Header.Get("crypto-pay-api-signature")
-	if len(sig) == 0 {
-		return Update{}, errors.New("crypto-pay-api-signature header was not found")
+	if d, ok := cb.timeouts[name]; ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return Update{}, fmt.Errorf("failed to read the update body: %w", err)
+	var retries int
+	if cb.retryAll || idempotentMethods[name] {
+		retries = cb.retries
 	}
 
-	hkey := sha256.Sum256([]byte(cb.token))
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		body, status, err := cb.send(ctx, method, url, data)
+		cb.logRequest(ctx, name, attempt, status, time.Since(start), err)
+		if attempt == retries || !retryable(ctx, status, err) {
+			return body, err
+		}
 
-	h := hmac.New(sha256.New, hkey[:])
-	if _, err := h.Write(body); err != nil {
-		return Update{}, fmt.Errorf("failed to create a new sha256 hmac: %w", err)
+		select {
+		case <-cb.after(cb.retryDelay(attempt)):
+		case <-ctx.Done():
+			return nil, closedError(ctx, ctx.Err())
+		}
 	}
+}
 
-	if sig != fmt.Sprintf("%x", h.Sum(nil)) {
-		return Update{}, errors.New("failed to verify the update")
+// retryDelay returns the backoff doubled once per previous retry, capped at MaxRetryBackoff so that it
+// cannot overflow however many retries are configured.
+func (cb cryptobot) retryDelay(attempt int) time.Duration {
+	d := cb.backoff
+	for range attempt {
+		if d >= MaxRetryBackoff/2 {
+			return MaxRetryBackoff
+		}
+		d *= 2
+	}
+	return d
+}
+
+// send makes a single request and returns the response body along with its status code.
+func (cb cryptobot) send(ctx context.Context, method, url string, data []byte) ([]byte, int, error) {
+	ctype := "application/json"
+	if cb.encoding == EncodingForm && data != nil {
+		form, err := formEncode(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		method, ctype, data = "POST", "application/x-www-form-urlencoded", form
 	}
 
-	var u Update
+	var r io.Reader
+	if data != nil {
+		r = bytes.NewReader(data)
+	}
 
-	if err := json.Unmarshal(body, &u); err != nil {
-		return Update{}, fmt.Errorf("failed to unmarshal the update: %w", err)
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return u, nil
-}
+	req.Header.Set("Crypto-Pay-API-Token", cb.token)
+	if len(cb.language) != 0 {
+		req.Header.Set("Accept-Language", cb.language)
+	}
+	// Some proxies reject bodiless requests that declare a content type.
+	if data != nil {
+		req.Header.Set("Content-Type", ctype)
+	}
+	if cb.signer != nil {
+		cb.signer(req, data)
+	}
 
-func (cb cryptobot) GetMe() (json.RawMessage, error) {
-	murl, err := url.JoinPath(cb.endpoint, "/getMe")
+	res, err := cb.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, closedError(ctx, &transportError{err: err})
 	}
+	defer res.Body.Close()
 
-	body, err := cb.makeRequest("GET", murl, nil)
+	body, err := io.ReadAll(io.LimitReader(res.Body, cb.maxBytes+1))
 	if err != nil {
-		return nil, err
+		return nil, res.StatusCode, closedError(ctx, err)
 	}
 
-	var res response[json.RawMessage]
+	if int64(len(body)) > cb.maxBytes {
+		return nil, res.StatusCode, fmt.Errorf("%w: exceeds %d bytes", ErrResponseTooLarge, cb.maxBytes)
+	}
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return nil, err
+	if !json.Valid(body) {
+		return nil, res.StatusCode, newResponseError(res.StatusCode, body)
 	}
 
-	if !res.Ok {
-		return nil, errors.New(string(res.Error))
+	if cb.tap != nil {
+		cb.tap.write(body)
 	}
 
-	return res.Result, nil
+	return body, res.StatusCode, nil
 }
 
-func (cb cryptobot) CreateInvoice(in NewInvoice) (Invoice, error) {
-	if err := validateNewInvoice(in); err != nil {
-		return Invoice{}, err
-	}
-
-	murl, err := url.JoinPath(cb.endpoint, "/createInvoice")
-	if err != nil {
-		return Invoice{}, err
-	}
+// tap writes response bodies to a writer as newline-delimited JSON.
+type tap struct {
+	mu sync.Mutex
+	w  io.Writer
+}
 
-	data, err := json.Marshal(in)
-	if err != nil {
-		return Invoice{}, err
+// write compacts the body onto a single line. Write errors are ignored, so a broken tap never fails a request.
+func (t *tap) write(body []byte) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		return
 	}
+	buf.WriteByte('\n')
 
-	body, err := cb.makeRequest("GET", murl, bytes.NewReader(data))
-	if err != nil {
-		return Invoice{}, err
-	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	var res response[Invoice]
+	t.w.Write(buf.Bytes())
+}
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return Invoice{}, err
+// formEncode converts a JSON object body into form values. Strings are sent as is, any other value
+// (numbers, booleans, arrays and objects) as its JSON text. Null values are left out.
+func formEncode(data []byte) ([]byte, error) {
+	var params map[string]json.RawMessage
+	if err := unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("form encoding: %w", err)
 	}
 
-	if !res.Ok {
-		return Invoice{}, errors.New(string(res.Error))
+	form := url.Values{}
+	for k, v := range params {
+		if string(v) == "null" {
+			continue
+		}
+
+		var s string
+		if err := unmarshal(v, &s); err != nil {
+			s = string(v)
+		}
+		form.Set(k, s)
 	}
 
-	return res.Result, nil
+	return []byte(form.Encode()), nil
 }
 
-func (cb cryptobot) DeleteInvoice(id int64) (bool, error) {
-	murl, err := url.JoinPath(cb.endpoint, "/deleteInvoice")
-	if err != nil {
-		return false, err
+// decodeResponse unmarshals the API response body and returns its result. A null or missing result
+// is decoded as the zero value of T without an error.
+func decodeResponse[T resultConstraint](body []byte) (T, error) {
+	var res response[json.RawMessage]
+	var result T
+
+	if err := unmarshal(body, &res); err != nil {
+		return result, err
 	}
 
-	data, err := json.Marshal(struct {
-		InvoiceID int64 `json:"invoice_id"`
-	}{InvoiceID: id})
+	if !res.Ok {
+		return result, decodeAPIError(res.Error)
+	}
 
-	if err != nil {
-		return false, err
+	if len(res.Result) == 0 || bytes.Equal(res.Result, []byte("null")) {
+		return result, nil
 	}
 
-	body, err := cb.makeRequest("POST", murl, bytes.NewReader(data))
-	if err != nil {
-		return false, err
+	if err := unmarshal(res.Result, &result); err != nil {
+		return result, err
 	}
 
-	var res response[bool]
+	return result, nil
+}
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return false, err
+// decodeAPIError decodes the error of a failed response, falling back to its raw text when it is not an APIError.
+func decodeAPIError(raw json.RawMessage) error {
+	var aerr APIError
+	if err := unmarshal(raw, &aerr); err != nil || len(aerr.Name) == 0 {
+		return errors.New(string(raw))
 	}
+	return &aerr
+}
 
-	if !res.Ok {
-		return false, errors.New(string(res.Error))
-	}
+// RawResponse is an API response whose result is left undecoded.
+type RawResponse struct {
+	// Whether the API reported the call as successful.
+	Ok bool `json:"ok"`
 
-	return res.Result, nil
+	// Result of a successful call.
+	Result json.RawMessage `json:"result"`
+
+	// Error of a failed call.
+	Error json.RawMessage `json:"error"`
 }
 
-func (cb cryptobot) GetInvoices(inop InvoiceOptions) ([]Invoice, error) {
-	if err := validateInvoiceOptions(inop); err != nil {
-		return nil, err
+// Err returns the error reported by the API, or nil when the call was successful.
+func (r RawResponse) Err() error {
+	if r.Ok {
+		return nil
 	}
+	return decodeAPIError(r.Error)
+}
 
-	murl, err := url.JoinPath(cb.endpoint, "/getInvoices")
+// decodePartial decodes every item of a list result separately. The items that fail are reported
+// in a *PartialResultError returned along with the rest.
+func decodePartial[T Invoice | Check | Transfer](body []byte) ([]T, error) {
+	raw, err := decodeResponse[items[json.RawMessage]](body)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := json.Marshal(inop)
-	if err != nil {
-		return nil, err
+	res := make([]T, 0, len(raw))
+	var perr PartialResultError
+
+	for i, data := range raw {
+		var v T
+		if err := unmarshal(data, &v); err != nil {
+			perr.Errors = append(perr.Errors, ItemError{Index: i, Err: err})
+			continue
+		}
+		res = append(res, v)
 	}
 
-	body, err := cb.makeRequest("POST", murl, bytes.NewReader(data))
-	if err != nil {
-		return nil, err
+	if len(perr.Errors) != 0 {
+		return res, &perr
 	}
 
-	var res response[struct {
-		Items []Invoice `json:"items"`
-	}]
+	return res, nil
+}
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return nil, err
-	}
+// DeleteResult is the full outcome of a delete request.
+type DeleteResult struct {
+	// Whether the API reported the record as deleted.
+	Deleted bool
 
-	if !res.Ok {
-		return nil, errors.New(string(res.Error))
-	}
+	// Raw result returned by the API.
+	Raw json.RawMessage
 
-	return res.Result.Items, nil
+	// Optional. Name of the API error the deletion was refused with (e.g. ErrNameInvoiceNotFound).
+	// A refusal is reported here instead of as an error.
+	Refused string
 }
 
-func (cb cryptobot) CreateCheck(nc NewCheck) (Check, error) {
-	if err := validateNewCheck(nc); err != nil {
-		return Check{}, err
-	}
+// decodeDeleteResult decodes the result of a delete request. An APIError is turned into a refused result,
+// so that it can be told apart from a false result.
+func decodeDeleteResult(body []byte) (DeleteResult, error) {
+	raw, err := decodeResponse[json.RawMessage](body)
 
-	murl, err := url.JoinPath(cb.endpoint, "/createCheck")
+	var aerr *APIError
+	if errors.As(err, &aerr) {
+		return DeleteResult{Refused: aerr.Name}, nil
+	}
 	if err != nil {
-		return Check{}, err
+		return DeleteResult{}, err
 	}
 
-	data, err := json.Marshal(nc)
-	if err != nil {
-		return Check{}, err
+	return DeleteResult{Deleted: bytes.Equal(raw, []byte("true")), Raw: raw}, nil
+}
+
+func (cb cryptobot) HandleUpdate(r *http.Request) (_ Update, err error) {
+	defer wrapError("HandleUpdate", &err)
+
+	return cb.handleUpdate(r)
+}
+
+func (cb cryptobot) handleUpdate(r *http.Request) (Update, error) {
+	sig := r.Header.Get("crypto-pay-api-signature")
+	if len(sig) == 0 {
+		return Update{}, errors.New("crypto-pay-api-signature header was not found")
 	}
 
-	body, err := cb.makeRequest("GET", murl, bytes.NewReader(data))
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return Check{}, err
+		return Update{}, fmt.Errorf("failed to read the update body: %w", err)
 	}
 
-	var res response[Check]
+	return verifyUpdate(cb.token, sig, body)
+}
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return Check{}, err
+// verifyUpdate checks the signature of an update body against the token and decodes the update.
+func verifyUpdate(token, sig string, body []byte) (Update, error) {
+	hkey := sha256.Sum256([]byte(token))
+
+	h := hmac.New(sha256.New, hkey[:])
+	if _, err := h.Write(body); err != nil {
+		return Update{}, fmt.Errorf("failed to create a new sha256 hmac: %w", err)
 	}
 
-	if !res.Ok {
-		return Check{}, errors.New(string(res.Error))
+	if sig != fmt.Sprintf("%x", h.Sum(nil)) {
+		return Update{}, errors.New("failed to verify the update")
 	}
 
-	return res.Result, nil
-}
+	var u Update
 
-func (cb cryptobot) DeleteCheck(id int64) (bool, error) {
-	murl, err := url.JoinPath(cb.endpoint, "/deleteCheck")
-	if err != nil {
-		return false, err
+	if err := unmarshal(body, &u); err != nil {
+		return Update{}, fmt.Errorf("failed to unmarshal the update: %w", err)
 	}
 
-	data, err := json.Marshal(struct {
-		CheckID int64 `json:"check_id"`
-	}{CheckID: id})
+	return u, nil
+}
 
+func (cb cryptobot) HandleUpdateVerified(ctx context.Context, r *http.Request) (_ Update, err error) {
+	defer wrapError("HandleUpdateVerified", &err)
+
+	u, err := cb.handleUpdate(r)
 	if err != nil {
-		return false, err
+		return Update{}, err
 	}
 
-	body, err := cb.makeRequest("POST", murl, bytes.NewReader(data))
+	ins, err := cb.getInvoices(ctx, InvoiceOptions{InvoiceIDs: []int64{u.Payload.ID}})
 	if err != nil {
-		return false, err
+		return Update{}, fmt.Errorf("failed to confirm invoice %d: %w", u.Payload.ID, err)
 	}
 
-	var res response[bool]
-
-	if err := json.Unmarshal(body, &res); err != nil {
-		return false, err
+	for _, in := range ins {
+		if in.ID != u.Payload.ID {
+			continue
+		}
+		if in.Status != InvoicePaid {
+			return Update{}, fmt.Errorf("invoice %d has status %s according to the api", in.ID, in.Status)
+		}
+		return u, nil
 	}
 
-	if !res.Ok {
-		return false, errors.New(string(res.Error))
-	}
+	return Update{}, fmt.Errorf("invoice %d was not found", u.Payload.ID)
+}
 
-	return res.Result, nil
+func (cb cryptobot) Ping(ctx context.Context) (err error) {
+	defer wrapError("Ping", &err)
+
+	_, err = cb.getMe(ctx)
+	return err
 }
 
-func (cb cryptobot) GetChecks(ckops CheckOptions) ([]Check, error) {
-	if err := validateCheckOptions(ckops); err != nil {
-		return nil, err
+// networkBots are the payment processing bots of the networks, keyed by their endpoint.
+var networkBots = map[string]string{
+	Mainnet: "CryptoBot",
+	Testnet: "CryptoTestnetBot",
+}
+
+func (cb cryptobot) VerifyNetwork() (err error) {
+	defer wrapError("VerifyNetwork", &err)
+
+	want, ok := networkBots[cb.endpoint]
+	if !ok {
+		return fmt.Errorf("the network of endpoint %q is unknown", cb.endpoint)
 	}
 
-	murl, err := url.JoinPath(cb.endpoint, "/getChecks")
+	raw, err := cb.getMe(context.Background())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	data, err := json.Marshal(ckops)
-	if err != nil {
-		return nil, err
+	var me struct {
+		Bot string `json:"payment_processing_bot_username"`
+	}
+	if err := unmarshal(raw, &me); err != nil {
+		return fmt.Errorf("failed to decode the app: %w", err)
+	}
+	if len(me.Bot) == 0 {
+		return errors.New("the app does not report its payment processing bot")
 	}
 
-	body, err := cb.makeRequest("POST", murl, bytes.NewReader(data))
-	if err != nil {
-		return nil, err
+	if me.Bot != want {
+		return fmt.Errorf("%w: the app is processed by %s, but the endpoint %s is served by %s", ErrNetworkMismatch, me.Bot, cb.endpoint, want)
 	}
 
-	var res response[struct {
-		Items []Check `json:"items"`
-	}]
+	return nil
+}
+
+func (cb cryptobot) AppID() (_ int64, err error) {
+	defer wrapError("AppID", &err)
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return nil, err
+	prefix, secret, ok := strings.Cut(cb.token, ":")
+	if !ok || len(secret) == 0 {
+		return 0, errors.New("token is malformed")
 	}
 
-	if !res.Ok {
-		return nil, errors.New(string(res.Error))
+	id, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("token has an invalid app id %q", prefix)
 	}
 
-	return res.Result.Items, nil
+	return id, nil
 }
 
-func (cb cryptobot) CreateTransfer(nt NewTransfer) (Transfer, error) {
-	if err := validateNewTransfer(nt); err != nil {
-		return Transfer{}, err
+func (cb cryptobot) Do(ctx context.Context, method string, params any) (_ RawResponse, err error) {
+	defer wrapError("Do", &err)
+
+	if len(method) == 0 {
+		return RawResponse{}, errors.New("method cannot be empty")
 	}
 
-	murl, err := url.JoinPath(cb.endpoint, "/transfer")
+	murl, err := url.JoinPath(cb.endpoint, method)
 	if err != nil {
-		return Transfer{}, err
+		return RawResponse{}, err
 	}
 
-	data, err := json.Marshal(nt)
-	if err != nil {
-		return Transfer{}, err
+	var data []byte
+	if params != nil {
+		if data, err = marshal(params); err != nil {
+			return RawResponse{}, err
+		}
 	}
 
-	body, err := cb.makeRequest("GET", murl, bytes.NewReader(data))
+	body, err := cb.makeRequest(ctx, "POST", murl, data)
 	if err != nil {
-		return Transfer{}, err
+		return RawResponse{}, err
 	}
 
-	var res response[Transfer]
+	var res RawResponse
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return Transfer{}, err
+	if err := unmarshal(body, &res); err != nil {
+		return RawResponse{}, err
 	}
 
-	if !res.Ok {
-		return Transfer{}, errors.New(string(res.Error))
+	return res, nil
+}
+
+func (cb cryptobot) GetMe() (json.RawMessage, error) {
+	return cb.GetMeContext(context.Background())
+}
+
+func (cb cryptobot) GetMeContext(ctx context.Context) (_ json.RawMessage, err error) {
+	defer wrapError("GetMe", &err)
+
+	return cb.getMe(ctx)
+}
+
+func (cb cryptobot) getMe(ctx context.Context) (json.RawMessage, error) {
+	murl, err := url.JoinPath(cb.endpoint, "/getMe")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := cb.makeRequest(ctx, "GET", murl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeResponse[json.RawMessage](body)
+}
+
+func (cb cryptobot) CreateInvoice(in NewInvoice) (Invoice, error) {
+	return cb.CreateInvoiceContext(context.Background(), in)
+}
+
+func (cb cryptobot) CreateInvoiceContext(ctx context.Context, in NewInvoice) (_ Invoice, err error) {
+	defer wrapError("CreateInvoice", &err)
+
+	return cb.createInvoice(ctx, in)
+}
+
+func (cb cryptobot) createInvoice(ctx context.Context, in NewInvoice) (_ Invoice, err error) {
+	if len(in.CurrencyType) == 0 {
+		in.CurrencyType = cb.defType
+	}
+	if in.CurrencyType == Crypto && len(in.CryptoAsset) == 0 {
+		in.CryptoAsset = cb.defAsset
+	}
+
+	if in.AcceptAllAssets && in.CurrencyType == Fiat && len(in.AcceptedCryptoAssets) == 0 {
+		cs, err := cb.getCurrencies(ctx)
+		if err != nil {
+			return Invoice{}, fmt.Errorf("failed to get the accepted assets: %w", err)
+		}
+
+		for _, c := range cs {
+			if c.IsBlockchain && (c.IsStablecoin || !in.StablecoinsOnly) {
+				in.AcceptedCryptoAssets = append(in.AcceptedCryptoAssets, CryptoAsset(c.Code))
+			}
+		}
+	}
+
+	if err := validateNewInvoice(in); err != nil {
+		return Invoice{}, err
+	}
+
+	if in.CurrencyType == Crypto {
+		in.Amount, err = cb.fitAssetAmount(ctx, in.CryptoAsset, in.Amount)
+	} else {
+		in.Amount, err = cb.fitAmount(in.Amount, fiatDecimals)
+	}
+	if err != nil {
+		return Invoice{}, err
+	}
+	if err := cb.checkMaxAmount(ctx, in); err != nil {
+		return Invoice{}, err
+	}
+
+	murl, err := url.JoinPath(cb.endpoint, "/createInvoice")
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	data, err := marshal(in)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	body, err := cb.makeRequest(ctx, "GET", murl, data)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	return decodeResponse[Invoice](body)
+}
+
+func (cb cryptobot) NewInvoiceFromMinorUnits(asset CryptoAsset, units int64) (_ NewInvoice, err error) {
+	defer wrapError("NewInvoiceFromMinorUnits", &err)
+
+	decimals, ok, err := cb.decimals.get(asset, func() ([]Currency, error) { return cb.getCurrencies(context.Background()) })
+	if err != nil {
+		return NewInvoice{}, err
+	}
+	if !ok {
+		return NewInvoice{}, fmt.Errorf("asset %s is not supported", asset)
+	}
+
+	return NewInvoice{CurrencyType: Crypto, CryptoAsset: asset, Amount: AmountFromMinorUnits(units, decimals)}, nil
+}
+
+// fitAssetAmount is fitAmount at the precision of the asset reported by getCurrencies, which is fetched once per
+// client. The built-in precision is only used for assets missing from the list, and amounts of assets unknown
+// to both are left to the API.
+func (cb cryptobot) fitAssetAmount(ctx context.Context, asset CryptoAsset, amount string) (string, error) {
+	decimals, ok, err := cb.decimals.get(asset, func() ([]Currency, error) { return cb.getCurrencies(ctx) })
+	if err != nil {
+		return "", fmt.Errorf("failed to get the precision of %s: %w", asset, err)
+	}
+	if !ok {
+		decimals, ok = assetDecimals[asset]
+	}
+	if !ok {
+		return amount, nil
+	}
+
+	return cb.fitAmount(amount, decimals)
+}
+
+// checkMaxAmount rejects invoices worth more than MaxInvoiceAmountUSD. Fiat amounts in other currencies are converted
+// through a crypto asset with rates in both currencies.
+func (cb cryptobot) checkMaxAmount(ctx context.Context, in NewInvoice) error {
+	if len(cb.maxUSD) == 0 {
+		return nil
+	}
+
+	ceiling, err := parseAmount(cb.maxUSD)
+	if err != nil || ceiling.Sign() == 0 {
+		return nil
+	}
+
+	a, err := parseAmount(in.Amount)
+	if err != nil {
+		return nil
+	}
+
+	if in.CurrencyType == Fiat && in.Fiat == USD {
+		return exceedsMax(in, a, ceiling)
+	}
+
+	rates, err := cb.getExchangeRates(ctx)
+	if err != nil {
+		return nil
+	}
+	rates = slices.DeleteFunc(rates, func(r ExchangeRate) bool { return !r.IsValid })
+
+	rate := func(src CryptoAsset, dst CurrencyCode) *big.Rat {
+		i := slices.IndexFunc(rates, func(r ExchangeRate) bool { return r.Source == src && r.Target == dst })
+		if i < 0 {
+			return nil
+		}
+		r, err := parseAmount(rates[i].Rate)
+		if err != nil || r.Sign() <= 0 {
+			return nil
+		}
+		return r
+	}
+
+	if in.CurrencyType == Crypto {
+		if r := rate(in.CryptoAsset, USD); r != nil {
+			return exceedsMax(in, a.Mul(a, r), ceiling)
+		}
+		return nil
+	}
+
+	for _, asset := range SupportedCryptoAssets() {
+		fr, ur := rate(asset, in.Fiat), rate(asset, USD)
+		if fr != nil && ur != nil {
+			return exceedsMax(in, a.Mul(a, ur).Quo(a, fr), ceiling)
+		}
+	}
+
+	return nil
+}
+
+func exceedsMax(in NewInvoice, usd, ceiling *big.Rat) error {
+	if usd.Cmp(ceiling) <= 0 {
+		return nil
+	}
+
+	currency := string(in.CryptoAsset)
+	if in.CurrencyType == Fiat {
+		currency = string(in.Fiat)
+	}
+
+	return fmt.Errorf("Amount %s %s is worth about %s USD, which exceeds MaxInvoiceAmountUSD of %s",
+		in.Amount, currency, usd.FloatString(fiatDecimals), ceiling.FloatString(fiatDecimals))
+}
+
+// fitAmount rejects amounts with more decimal places than given, or rounds them when RoundAmounts is set.
+// Trailing zeros do not count, and amounts that cannot be parsed are left to the API.
+func (cb cryptobot) fitAmount(amount string, decimals int) (string, error) {
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return amount, nil
+	}
+
+	if prec, exact := r.FloatPrec(); exact && prec <= decimals {
+		return amount, nil
+	}
+
+	if !cb.round {
+		return "", fmt.Errorf("Amount %s has more than the %d decimal places supported by the asset", amount, decimals)
+	}
+
+	rounded := r.FloatString(decimals)
+	if z, _ := new(big.Rat).SetString(rounded); z.Sign() <= 0 {
+		return "", fmt.Errorf("Amount %s rounds to zero at %d decimal places", amount, decimals)
+	}
+
+	return rounded, nil
+}
+
+func (cb cryptobot) RenewInvoice(old Invoice) (_ Invoice, err error) {
+	defer wrapError("RenewInvoice", &err)
+
+	// Paid invoices are rejected too, since renewing them would bill the customer twice.
+	if old.Status != InvoiceExpired {
+		return Invoice{}, fmt.Errorf("invoice %d has status %s, only expired invoices can be renewed", old.ID, old.Status)
+	}
+
+	in, err := renewal(old)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	return cb.createInvoice(context.Background(), in)
+}
+
+func (cb cryptobot) DeleteInvoice(id int64) (bool, error) {
+	return cb.DeleteInvoiceContext(context.Background(), id)
+}
+
+func (cb cryptobot) DeleteInvoiceContext(ctx context.Context, id int64) (_ bool, err error) {
+	defer wrapError("DeleteInvoice", &err)
+
+	return cb.deleteInvoiceOK(ctx, id)
+}
+
+func (cb cryptobot) DeleteInvoiceResult(id int64) (_ DeleteResult, err error) {
+	defer wrapError("DeleteInvoiceResult", &err)
+
+	body, err := cb.deleteInvoice(context.Background(), id)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+
+	return decodeDeleteResult(body)
+}
+
+// deleteInvoiceOK deletes the invoice and decodes its boolean result.
+func (cb cryptobot) deleteInvoiceOK(ctx context.Context, id int64) (bool, error) {
+	body, err := cb.deleteInvoice(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	return decodeResponse[bool](body)
+}
+
+func (cb cryptobot) deleteInvoice(ctx context.Context, id int64) ([]byte, error) {
+	murl, err := url.JoinPath(cb.endpoint, "/deleteInvoice")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := marshal(struct {
+		InvoiceID int64 `json:"invoice_id"`
+	}{InvoiceID: id})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cb.makeRequest(ctx, "POST", murl, data)
+}
+
+func (cb cryptobot) DeleteInvoiceIdempotent(id int64) (_ bool, err error) {
+	defer wrapError("DeleteInvoiceIdempotent", &err)
+
+	ok, err := cb.deleteInvoiceOK(context.Background(), id)
+
+	if IsErrorName(err, ErrNameInvoiceNotFound) {
+		return true, nil
+	}
+
+	return ok, err
+}
+
+// pageSize returns the given count, or the configured default count when it is zero.
+func (cb cryptobot) pageSize(count int64) int64 {
+	if count == 0 {
+		return cb.defCount
+	}
+	return count
+}
+
+func (cb cryptobot) GetInvoices(inop InvoiceOptions) ([]Invoice, error) {
+	return cb.GetInvoicesContext(context.Background(), inop)
+}
+
+func (cb cryptobot) GetInvoicesContext(ctx context.Context, inop InvoiceOptions) (_ []Invoice, err error) {
+	defer wrapError("GetInvoices", &err)
+
+	return cb.getInvoices(ctx, inop)
+}
+
+func (cb cryptobot) getInvoices(ctx context.Context, inop InvoiceOptions) ([]Invoice, error) {
+	if err := validateInvoiceOptions(inop); err != nil {
+		return nil, err
+	}
+	inop.Count = cb.pageSize(inop.Count)
+
+	murl, err := url.JoinPath(cb.endpoint, "/getInvoices")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := marshal(inop)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := cb.makeRequest(ctx, "POST", murl, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var ins []Invoice
+	if cb.partial {
+		ins, err = decodePartial[Invoice](body)
+	} else {
+		ins, err = decodeResponse[items[Invoice]](body)
+	}
+
+	sortInvoices(ins, inop.Order)
+
+	return ins, err
+}
+
+func (cb cryptobot) GetInvoicesPage(inop InvoiceOptions) (_ []Invoice, _ bool, err error) {
+	defer wrapError("GetInvoicesPage", &err)
+
+	ins, err := cb.getInvoices(context.Background(), inop)
+	if err != nil {
+		// Keep the invoices decoded in partial results mode.
+		return ins, false, err
+	}
+
+	count := cb.pageSize(inop.Count)
+	if count == 0 {
+		count = defaultCount
+	}
+
+	return ins, int64(len(ins)) >= count, nil
+}
+
+func (cb cryptobot) GetInvoicesByIDs(ids []int64) ([]Invoice, error) {
+	return cb.GetInvoicesByIDsContext(context.Background(), ids)
+}
+
+func (cb cryptobot) GetInvoicesByIDsContext(ctx context.Context, ids []int64) (_ []Invoice, err error) {
+	defer wrapError("GetInvoicesByIDs", &err)
+
+	return cb.getInvoicesByIDs(ctx, ids)
+}
+
+func (cb cryptobot) getInvoicesByIDs(ctx context.Context, ids []int64) ([]Invoice, error) {
+	seen := make(map[int64]bool, len(ids))
+	uniq := make([]int64, 0, len(ids))
+
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			uniq = append(uniq, id)
+		}
+	}
+
+	ins := make([]Invoice, 0, len(uniq))
+
+	for chunk := range slices.Chunk(uniq, MaxIDsPerRequest) {
+		res, err := cb.getInvoices(ctx, InvoiceOptions{InvoiceIDs: chunk, Count: int64(len(chunk))})
+		if err != nil {
+			return nil, err
+		}
+
+		ins = append(ins, res...)
+	}
+
+	return ins, nil
+}
+
+func (cb cryptobot) PollInvoices(ids []int64) (_ map[int64]Invoice, err error) {
+	defer wrapError("PollInvoices", &err)
+
+	ins, err := cb.getInvoicesByIDs(context.Background(), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int64]Invoice, len(ins))
+	for _, in := range ins {
+		m[in.ID] = in
+	}
+
+	return m, nil
+}
+
+func (cb cryptobot) FilterInvoices(opts InvoiceOptions, pred func(Invoice) bool) iter.Seq2[Invoice, error] {
+	return func(yield func(Invoice, error) bool) {
+		count := cb.pageSize(opts.Count)
+		if count == 0 {
+			count = defaultCount
+		}
+		opts.Count = count
+
+		for {
+			ins, err := cb.getInvoices(context.Background(), opts)
+			if err != nil {
+				yield(Invoice{}, fmt.Errorf("FilterInvoices: %w", err))
+				return
+			}
+
+			for _, in := range ins {
+				if pred(in) && !yield(in, nil) {
+					return
+				}
+			}
+
+			if int64(len(ins)) < count {
+				return
+			}
+
+			opts.Offset += int64(len(ins))
+		}
+	}
+}
+
+func (cb cryptobot) GetInvoicesByPayload(payload string, base InvoiceOptions) (_ []Invoice, err error) {
+	defer wrapError("GetInvoicesByPayload", &err)
+
+	if base.Count == 0 {
+		base.Count = 1000
+	}
+
+	var found []Invoice
+
+	for {
+		ins, err := cb.getInvoices(context.Background(), base)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, in := range ins {
+			if in.Payload == payload {
+				found = append(found, in)
+			}
+		}
+
+		if int64(len(ins)) < base.Count {
+			return found, nil
+		}
+
+		base.Offset += int64(len(ins))
+	}
+}
+
+func (cb cryptobot) DeleteExpiredInvoices(ctx context.Context) (_ int, err error) {
+	defer wrapError("DeleteExpiredInvoices", &err)
+
+	var expired []Invoice
+
+	for offset := int64(0); ; {
+		ins, err := cb.getInvoices(ctx, InvoiceOptions{Status: InvoiceExpired, Offset: offset, Count: 1000})
+		if err != nil {
+			return 0, err
+		}
+
+		expired = append(expired, ins...)
+		offset += int64(len(ins))
+
+		if len(ins) < 1000 {
+			break
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		deleted int
+		errs    []error
+	)
+
+	sem := make(chan struct{}, DeleteConcurrency)
+
+	for _, in := range expired {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		// A free slot and a done context may be ready at once, so the context is checked either way.
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+
+		go func(id int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			ok, err := cb.deleteInvoiceOK(ctx, id)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete invoice %d: %w", id, err))
+				return
+			}
+			if ok {
+				deleted++
+			}
+		}(in.ID)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return deleted, err
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+func (cb cryptobot) CreateCheck(nc NewCheck) (Check, error) {
+	return cb.CreateCheckContext(context.Background(), nc)
+}
+
+func (cb cryptobot) CreateCheckContext(ctx context.Context, nc NewCheck) (_ Check, err error) {
+	defer wrapError("CreateCheck", &err)
+
+	return cb.createCheck(ctx, nc)
+}
+
+func (cb cryptobot) createCheck(ctx context.Context, nc NewCheck) (_ Check, err error) {
+	if err := validateNewCheck(nc); err != nil {
+		return Check{}, err
+	}
+
+	if nc.Amount, err = cb.fitAssetAmount(ctx, nc.CryptoAsset, nc.Amount); err != nil {
+		return Check{}, err
+	}
+
+	murl, err := url.JoinPath(cb.endpoint, "/createCheck")
+	if err != nil {
+		return Check{}, err
+	}
+
+	data, err := marshal(nc)
+	if err != nil {
+		return Check{}, err
+	}
+
+	body, err := cb.makeRequest(ctx, "GET", murl, data)
+	if err != nil {
+		return Check{}, err
+	}
+
+	return decodeResponse[Check](body)
+}
+
+func (cb cryptobot) CreateChecks(asset CryptoAsset, amount string, n int, concurrency int) (_ []Check, err error) {
+	defer wrapError("CreateChecks", &err)
+
+	nc := NewCheck{CryptoAsset: asset, Amount: amount}
+
+	if err := validateNewCheck(nc); err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, errors.New("number of checks should be positive")
+	}
+	if concurrency < 1 {
+		return nil, errors.New("concurrency should be positive")
 	}
 
-	return res.Result, nil
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		chs  []Check
+		errs []error
+	)
+
+	sem := make(chan struct{}, concurrency)
+
+	for range n {
+		sem <- struct{}{}
+
+		mu.Lock()
+		failed := len(errs) != 0
+		mu.Unlock()
+
+		if failed {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			ch, err := cb.createCheck(context.Background(), nc)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			chs = append(chs, ch)
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) != 0 {
+		return chs, fmt.Errorf("created %d of %d checks: %w", len(chs), n, errors.Join(errs...))
+	}
+
+	return chs, nil
+}
+
+func (cb cryptobot) DeleteCheck(id int64) (bool, error) {
+	return cb.DeleteCheckContext(context.Background(), id)
+}
+
+func (cb cryptobot) DeleteCheckContext(ctx context.Context, id int64) (_ bool, err error) {
+	defer wrapError("DeleteCheck", &err)
+
+	body, err := cb.deleteCheck(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	return decodeResponse[bool](body)
+}
+
+func (cb cryptobot) DeleteCheckResult(id int64) (_ DeleteResult, err error) {
+	defer wrapError("DeleteCheckResult", &err)
+
+	body, err := cb.deleteCheck(context.Background(), id)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+
+	return decodeDeleteResult(body)
+}
+
+func (cb cryptobot) deleteCheck(ctx context.Context, id int64) ([]byte, error) {
+	murl, err := url.JoinPath(cb.endpoint, "/deleteCheck")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := marshal(struct {
+		CheckID int64 `json:"check_id"`
+	}{CheckID: id})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cb.makeRequest(ctx, "POST", murl, data)
+}
+
+func (cb cryptobot) GetChecks(ckops CheckOptions) ([]Check, error) {
+	return cb.GetChecksContext(context.Background(), ckops)
+}
+
+func (cb cryptobot) GetChecksContext(ctx context.Context, ckops CheckOptions) (_ []Check, err error) {
+	defer wrapError("GetChecks", &err)
+
+	if err := validateCheckOptions(ckops); err != nil {
+		return nil, err
+	}
+	ckops.Count = cb.pageSize(ckops.Count)
+
+	murl, err := url.JoinPath(cb.endpoint, "/getChecks")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := marshal(ckops)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := cb.makeRequest(ctx, "POST", murl, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if cb.partial {
+		return decodePartial[Check](body)
+	}
+
+	return decodeResponse[items[Check]](body)
+}
+
+func (cb cryptobot) CreateTransfer(nt NewTransfer) (Transfer, error) {
+	return cb.CreateTransferContext(context.Background(), nt)
+}
+
+func (cb cryptobot) CreateTransferContext(ctx context.Context, nt NewTransfer) (_ Transfer, err error) {
+	defer wrapError("CreateTransfer", &err)
+
+	return cb.createTransfer(ctx, nt)
+}
+
+func (cb cryptobot) createTransfer(ctx context.Context, nt NewTransfer) (_ Transfer, err error) {
+	// The spend id lets failed attempts be correlated with their retries. It is redacted like in Transfer.String.
+	defer wrapError(fmt.Sprintf("transfer(spend_id=%q)", redact(nt.SpendID)), &err)
+
+	if err := validateNewTransfer(nt); err != nil {
+		return Transfer{}, err
+	}
+
+	if nt.Amount, err = cb.fitAssetAmount(ctx, nt.CryptoAsset, nt.Amount); err != nil {
+		return Transfer{}, err
+	}
+
+	murl, err := url.JoinPath(cb.endpoint, "/transfer")
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	data, err := marshal(nt)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	body, err := cb.makeRequest(ctx, "GET", murl, data)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	return decodeResponse[Transfer](body)
+}
+
+func (cb cryptobot) CreateTransfers(nts []NewTransfer) (_ []Transfer, err error) {
+	defer wrapError("CreateTransfers", &err)
+
+	if err := validateNewTransfers(nts); err != nil {
+		return nil, err
+	}
+
+	trs := make([]Transfer, 0, len(nts))
+
+	for i, nt := range nts {
+		tr, err := cb.createTransfer(context.Background(), nt)
+		if err != nil {
+			return trs, fmt.Errorf("failed to send transfer %d: %w", i, err)
+		}
+
+		trs = append(trs, tr)
+	}
+
+	return trs, nil
 }
 
 func (cb cryptobot) GetTransfers(trops TransferOptions) ([]Transfer, error) {
+	return cb.GetTransfersContext(context.Background(), trops)
+}
+
+func (cb cryptobot) GetTransfersContext(ctx context.Context, trops TransferOptions) (_ []Transfer, err error) {
+	defer wrapError("GetTransfers", &err)
+
+	return cb.getTransfers(ctx, trops)
+}
+
+func (cb cryptobot) getTransfers(ctx context.Context, trops TransferOptions) ([]Transfer, error) {
 	if err := validateTransferOptions(trops); err != nil {
 		return nil, err
 	}
+	trops.Count = cb.pageSize(trops.Count)
 
 	murl, err := url.JoinPath(cb.endpoint, "/getTransfers")
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := json.Marshal(trops)
+	data, err := marshal(trops)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := cb.makeRequest("POST", murl, bytes.NewReader(data))
+	body, err := cb.makeRequest(ctx, "POST", murl, data)
 	if err != nil {
 		return nil, err
 	}
 
-	var res response[struct {
-		Items []Transfer `json:"items"`
-	}]
+	if cb.partial {
+		return decodePartial[Transfer](body)
+	}
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return nil, err
+	return decodeResponse[items[Transfer]](body)
+}
+
+func (cb cryptobot) ConfirmTransfer(spendID string) (Transfer, error) {
+	return cb.ConfirmTransferContext(context.Background(), spendID)
+}
+
+func (cb cryptobot) ConfirmTransferContext(ctx context.Context, spendID string) (_ Transfer, err error) {
+	defer wrapError("ConfirmTransfer", &err)
+
+	return cb.confirmTransfer(ctx, spendID)
+}
+
+func (cb cryptobot) confirmTransfer(ctx context.Context, spendID string) (Transfer, error) {
+	if len(spendID) == 0 {
+		return Transfer{}, errors.New("SpendID cannot be empty")
 	}
 
-	if !res.Ok {
-		return nil, errors.New(string(res.Error))
+	trs, err := cb.getTransfers(ctx, TransferOptions{SpendID: spendID})
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	for _, tr := range trs {
+		if tr.SpendID != spendID {
+			continue
+		}
+		if tr.Status != TransferCompleted {
+			return tr, &UnconfirmedTransferError{SpendID: spendID, Status: tr.Status}
+		}
+		return tr, nil
+	}
+
+	return Transfer{}, &UnconfirmedTransferError{SpendID: spendID}
+}
+
+func (cb cryptobot) ReconcileTransfers(expected []NewTransfer) (_ []NewTransfer, err error) {
+	defer wrapError("ReconcileTransfers", &err)
+
+	var missing []NewTransfer
+
+	for _, nt := range expected {
+		_, err := cb.confirmTransfer(context.Background(), nt.SpendID)
+
+		var uerr *UnconfirmedTransferError
+		if errors.As(err, &uerr) {
+			missing = append(missing, nt)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return res.Result.Items, nil
+	return missing, nil
 }
 
 func (cb cryptobot) GetBalance() ([]Balance, error) {
+	return cb.GetBalanceContext(context.Background())
+}
+
+func (cb cryptobot) GetBalanceContext(ctx context.Context) (_ []Balance, err error) {
+	defer wrapError("GetBalance", &err)
+
+	return cb.getBalance(ctx)
+}
+
+func (cb cryptobot) getBalance(ctx context.Context) ([]Balance, error) {
 	murl, err := url.JoinPath(cb.endpoint, "/getBalance")
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := cb.makeRequest("GET", murl, nil)
+	body, err := cb.makeRequest(ctx, "GET", murl, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var res response[[]Balance]
+	return decodeResponse[[]Balance](body)
+}
+
+func (cb cryptobot) GetBalanceMap() (map[CryptoAsset]Balance, error) {
+	return cb.GetBalanceMapContext(context.Background())
+}
+
+func (cb cryptobot) GetBalanceMapContext(ctx context.Context) (_ map[CryptoAsset]Balance, err error) {
+	defer wrapError("GetBalanceMap", &err)
+
+	return cb.getBalanceMap(ctx)
+}
 
-	if err := json.Unmarshal(body, &res); err != nil {
+func (cb cryptobot) getBalanceMap(ctx context.Context) (map[CryptoAsset]Balance, error) {
+	bs, err := cb.getBalance(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	if !res.Ok {
-		return nil, errors.New(string(res.Error))
+	m := make(map[CryptoAsset]Balance, len(bs))
+	for _, b := range bs {
+		m[b.CryptoAsset] = b
+	}
+
+	return m, nil
+}
+
+func (cb cryptobot) CanAfford(nt NewTransfer) (_ bool, err error) {
+	defer wrapError("CanAfford", &err)
+
+	need, err := parseAmount(nt.Amount)
+	if err != nil {
+		return false, err
+	}
+
+	if fee, ok := cb.buffers[nt.CryptoAsset]; ok {
+		buf, err := parseAmount(fee)
+		if err != nil {
+			return false, err
+		}
+		need.Add(need, buf)
+	}
+
+	bs, err := cb.getBalanceMap(context.Background())
+	if err != nil {
+		return false, err
 	}
 
-	return res.Result, nil
+	b, ok := bs[nt.CryptoAsset]
+	if !ok {
+		return need.Sign() <= 0, nil
+	}
+
+	av, err := b.AvailableAmount()
+	if err != nil {
+		return false, err
+	}
+
+	return av.Cmp(need) >= 0, nil
 }
 
 func (cb cryptobot) GetExchangeRates() ([]ExchangeRate, error) {
+	return cb.GetExchangeRatesContext(context.Background())
+}
+
+func (cb cryptobot) GetExchangeRatesContext(ctx context.Context) (_ []ExchangeRate, err error) {
+	defer wrapError("GetExchangeRates", &err)
+
+	return cb.getExchangeRates(ctx)
+}
+
+func (cb cryptobot) getExchangeRates(ctx context.Context) ([]ExchangeRate, error) {
+	snap, err := cb.getRatesSnapshot(ctx)
+	return snap.Rates, err
+}
+
+func (cb cryptobot) GetExchangeRatesSnapshot() (_ RatesSnapshot, err error) {
+	defer wrapError("GetExchangeRatesSnapshot", &err)
+
+	return cb.getRatesSnapshot(context.Background())
+}
+
+func (cb cryptobot) getRatesSnapshot(ctx context.Context) (RatesSnapshot, error) {
+	if cb.ratesTTL > 0 {
+		if snap, ok := cb.rates.get(cb.now(), cb.ratesTTL); ok {
+			return snap, nil
+		}
+	}
+
 	murl, err := url.JoinPath(cb.endpoint, "/getExchangeRates")
 	if err != nil {
-		return nil, err
+		return RatesSnapshot{}, err
+	}
+
+	body, err := cb.makeRequest(ctx, "GET", murl, nil)
+	if err != nil {
+		return RatesSnapshot{}, err
+	}
+
+	rates, err := decodeResponse[[]ExchangeRate](body)
+	if err != nil {
+		return RatesSnapshot{}, err
+	}
+
+	snap := RatesSnapshot{Rates: rates, FetchedAt: cb.now()}
+	if cb.ratesTTL > 0 {
+		cb.rates.set(snap)
 	}
 
-	body, err := cb.makeRequest("GET", murl, nil)
+	return snap, nil
+}
+
+func (cb cryptobot) StreamExchangeRates(ctx context.Context, interval time.Duration) (<-chan []ExchangeRate, <-chan error) {
+	ratesc := make(chan []ExchangeRate)
+	errc := make(chan error, 1)
+
+	if interval <= 0 {
+		errc <- fmt.Errorf("StreamExchangeRates: interval %s should be positive", interval)
+		close(ratesc)
+		close(errc)
+		return ratesc, errc
+	}
+
+	go func() {
+		defer close(errc)
+		defer close(ratesc)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			rates, err := cb.getExchangeRates(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case errc <- fmt.Errorf("StreamExchangeRates: %w", err):
+				default:
+				}
+			} else {
+				select {
+				case ratesc <- rates:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ratesc, errc
+}
+
+func (cb cryptobot) GetValidExchangeRates() ([]ExchangeRate, error) {
+	return cb.GetValidExchangeRatesContext(context.Background())
+}
+
+func (cb cryptobot) GetValidExchangeRatesContext(ctx context.Context) (_ []ExchangeRate, err error) {
+	defer wrapError("GetValidExchangeRates", &err)
+
+	return cb.getValidExchangeRates(ctx)
+}
+
+func (cb cryptobot) getValidExchangeRates(ctx context.Context) ([]ExchangeRate, error) {
+	rates, err := cb.getExchangeRates(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	var res response[[]ExchangeRate]
+	return slices.DeleteFunc(rates, func(r ExchangeRate) bool { return !r.IsValid }), nil
+}
+
+func (cb cryptobot) EstimateFiatValue(amount string, asset CryptoAsset, fiat CurrencyCode) (_ string, err error) {
+	defer wrapError("EstimateFiatValue", &err)
+
+	a, err := parseAmount(amount)
+	if err != nil {
+		return "", err
+	}
+
+	rates, err := cb.getValidExchangeRates(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	i := slices.IndexFunc(rates, func(r ExchangeRate) bool { return r.Source == asset && r.Target == fiat })
+	if i < 0 {
+		return "", fmt.Errorf("no up-to-date %s to %s exchange rate", asset, fiat)
+	}
+
+	rate, err := parseAmount(rates[i].Rate)
+	if err != nil {
+		return "", fmt.Errorf("%s to %s exchange rate is invalid: %w", asset, fiat, err)
+	}
+
+	value := new(big.Rat).Mul(a, rate)
+
+	return "≈ " + FormatFiat(value.FloatString(fiatDecimals), fiat), nil
+}
+
+func (cb cryptobot) GetCurrencies() ([]Currency, error) {
+	return cb.GetCurrenciesContext(context.Background())
+}
+
+func (cb cryptobot) GetCurrenciesContext(ctx context.Context) (_ []Currency, err error) {
+	defer wrapError("GetCurrencies", &err)
+
+	return cb.getCurrencies(ctx)
+}
 
-	if err := json.Unmarshal(body, &res); err != nil {
+func (cb cryptobot) getCurrencies(ctx context.Context) ([]Currency, error) {
+	murl, err := url.JoinPath(cb.endpoint, "/getCurrencies")
+	if err != nil {
 		return nil, err
 	}
 
-	if !res.Ok {
-		return nil, errors.New(string(res.Error))
+	body, err := cb.makeRequest(ctx, "GET", murl, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return res.Result, nil
+	return decodeResponse[[]Currency](body)
 }
 
 func (cb cryptobot) GetAppStats(asops AppStatsOptions) (AppStats, error) {
+	return cb.GetAppStatsContext(context.Background(), asops)
+}
+
+func (cb cryptobot) GetAppStatsContext(ctx context.Context, asops AppStatsOptions) (_ AppStats, err error) {
+	defer wrapError("GetAppStats", &err)
+
+	return cb.getAppStats(ctx, asops)
+}
+
+func (cb cryptobot) getAppStats(ctx context.Context, asops AppStatsOptions) (AppStats, error) {
 	murl, err := url.JoinPath(cb.endpoint, "/getStats")
 	if err != nil {
 		return AppStats{}, err
 	}
 
-	data, err := json.Marshal(asops)
+	data, err := marshal(asops)
 	if err != nil {
 		return AppStats{}, err
 	}
 
-	body, err := cb.makeRequest("POST", murl, bytes.NewReader(data))
+	body, err := cb.makeRequest(ctx, "POST", murl, data)
 	if err != nil {
 		return AppStats{}, err
 	}
 
-	var res response[AppStats]
+	return decodeResponse[AppStats](body)
+}
 
-	if err := json.Unmarshal(body, &res); err != nil {
-		return AppStats{}, err
+func (cb cryptobot) GetAppStatsMulti(windows []time.Duration) (_ map[time.Duration]AppStats, err error) {
+	defer wrapError("GetAppStatsMulti", &err)
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+
+	// Every window is validated before any request is sent, so an invalid one cannot leave others running.
+	for _, w := range windows {
+		if w <= 0 {
+			return nil, fmt.Errorf("window %s should be positive", w)
+		}
 	}
 
-	if !res.Ok {
-		return AppStats{}, errors.New(string(res.Error))
+	now := cb.now()
+	stats := make(map[time.Duration]AppStats, len(windows))
+
+	for _, w := range windows {
+		wg.Add(1)
+
+		go func(w time.Duration) {
+			defer wg.Done()
+
+			st, err := cb.getAppStats(context.Background(), AppStatsOptions{StartAt: now.Add(-w), EndAt: now})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to get the application statistics for %s: %w", w, err))
+				return
+			}
+			stats[w] = st
+		}(w)
+	}
+
+	wg.Wait()
+
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return stats, nil
+}
+
+func (cb cryptobot) GetAppStatsRange(start, end time.Time, window time.Duration) (_ []AppStats, err error) {
+	defer wrapError("GetAppStatsRange", &err)
+
+	if window <= 0 {
+		return nil, fmt.Errorf("window %s should be positive", window)
+	}
+	if !start.Before(end) {
+		return nil, errors.New("start should be before end")
+	}
+
+	var windows []AppStatsOptions
+	for from := start; from.Before(end); from = from.Add(window) {
+		to := from.Add(window)
+		if to.After(end) {
+			to = end
+		}
+		windows = append(windows, AppStatsOptions{StartAt: from, EndAt: to})
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+
+	stats := make([]AppStats, len(windows))
+	sem := make(chan struct{}, AppStatsConcurrency)
+
+	for i, w := range windows {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			st, err := cb.getAppStats(context.Background(), w)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("failed to get the application statistics from %s to %s: %w",
+					w.StartAt.Format(time.RFC3339), w.EndAt.Format(time.RFC3339), err))
+				mu.Unlock()
+				return
+			}
+			stats[i] = st
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
 	}
 
-	return res.Result, nil
+	return stats, nil
 }
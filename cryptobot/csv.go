@@ -0,0 +1,70 @@
+package cryptobot
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// invoiceCSVHeader is the header row written by WriteInvoicesCSV.
+var invoiceCSVHeader = []string{
+	"invoice_id", "status", "currency_type", "amount", "asset", "fiat", "paid_asset", "paid_amount", "created_at", "paid_at",
+}
+
+// WriteInvoicesCSV writes the invoices as CSV with a header row and one row per invoice. Timestamps are
+// normalized to RFC 3339 in UTC and left empty when the invoice has none (e.g. paid_at of an unpaid invoice).
+func WriteInvoicesCSV(w io.Writer, invs []Invoice) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(invoiceCSVHeader); err != nil {
+		return err
+	}
+
+	for _, in := range invs {
+		created, err := csvTime(in.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("invoice %d has an invalid creation date: %w", in.ID, err)
+		}
+
+		paid, err := csvTime(in.PaidAt)
+		if err != nil {
+			return fmt.Errorf("invoice %d has an invalid payment date: %w", in.ID, err)
+		}
+
+		err = cw.Write([]string{
+			strconv.FormatInt(in.ID, 10),
+			string(in.Status),
+			string(in.CurrencyType),
+			string(in.Amount),
+			string(in.CryptoAsset),
+			string(in.Fiat),
+			string(in.PaidAsset),
+			string(in.PaidAmount),
+			created,
+			paid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// csvTime normalizes an API date to RFC 3339 in UTC. Empty dates stay empty.
+func csvTime(s string) (string, error) {
+	if len(s) == 0 {
+		return "", nil
+	}
+
+	t, err := parseDate(s)
+	if err != nil {
+		return "", err
+	}
+
+	return t.UTC().Format(time.RFC3339), nil
+}
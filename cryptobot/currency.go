@@ -0,0 +1,57 @@
+package cryptobot
+
+import "sync"
+
+type Currency struct {
+	// Whether or not the currency is a blockchain asset.
+	IsBlockchain bool `json:"is_blockchain"`
+
+	// Whether or not the currency is a stablecoin.
+	IsStablecoin bool `json:"is_stablecoin"`
+
+	// Whether or not the currency is a fiat currency.
+	IsFiat bool `json:"is_fiat"`
+
+	// Name of the currency.
+	Name string `json:"name"`
+
+	// Currency code (e.g. USDT or USD).
+	Code string `json:"code"`
+
+	// Optional. URL of the currency's website.
+	URL string `json:"url,omitempty"`
+
+	// Number of decimal places the currency supports.
+	Decimals int `json:"decimals"`
+}
+
+// precisionCache holds the decimal places of the blockchain assets reported by getCurrencies. The list is fetched
+// once per client, since the supported assets rarely change.
+type precisionCache struct {
+	mu       sync.Mutex
+	decimals map[CryptoAsset]int
+}
+
+// get returns the decimal places of the asset and whether the API knows it, fetching the list on its first use.
+// A failed fetch is returned and retried on the next call.
+func (c *precisionCache) get(asset CryptoAsset, fetch func() ([]Currency, error)) (int, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.decimals == nil {
+		cs, err := fetch()
+		if err != nil {
+			return 0, false, err
+		}
+
+		c.decimals = make(map[CryptoAsset]int, len(cs))
+		for _, cur := range cs {
+			if cur.IsBlockchain {
+				c.decimals[CryptoAsset(cur.Code)] = cur.Decimals
+			}
+		}
+	}
+
+	decimals, ok := c.decimals[asset]
+	return decimals, ok, nil
+}
@@ -0,0 +1,141 @@
+package cryptobot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrResponseTooLarge is returned when a response body exceeds the configured maximum size.
+var ErrResponseTooLarge = errors.New("response body is too large")
+
+// ErrClientClosed is returned by requests made after Shutdown and is the cause of the requests it cancelled.
+var ErrClientClosed = errors.New("client is shut down")
+
+// ErrNetworkMismatch is returned by VerifyNetwork when the app belongs to a different network than the endpoint.
+var ErrNetworkMismatch = errors.New("network mismatch")
+
+// ErrUnauthorized is matched by errors.Is when the API rejects the token.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Common error names returned by the API.
+const (
+	ErrNameUnauthorized     = "UNAUTHORIZED"
+	ErrNameMethodNotFound   = "METHOD_NOT_FOUND"
+	ErrNameMethodDisabled   = "METHOD_DISABLED"
+	ErrNameInvoiceNotFound  = "INVOICE_NOT_FOUND"
+	ErrNameCheckNotFound    = "CHECK_NOT_FOUND"
+	ErrNameNotEnoughCoins   = "NOT_ENOUGH_COINS"
+	ErrNameAmountTooSmall   = "AMOUNT_TOO_SMALL"
+	ErrNameAmountTooBig     = "AMOUNT_TOO_BIG"
+	ErrNameAssetInvalid     = "ASSET_INVALID"
+	ErrNameUserNotFound     = "USER_NOT_FOUND"
+	ErrNameSpendIDInvalid   = "SPEND_ID_INVALID"
+	ErrNameExpiresInInvalid = "EXPIRES_IN_INVALID"
+)
+
+// IsErrorName reports whether err is an APIError with the given name (e.g. ErrNameNotEnoughCoins).
+func IsErrorName(err error, name string) bool {
+	var aerr *APIError
+	return errors.As(err, &aerr) && aerr.Name == name
+}
+
+// APIError is an error returned by the Crypto Pay API in the error field of an unsuccessful response.
+type APIError struct {
+	// Error code (e.g. 400).
+	Code int `json:"code"`
+
+	// Error name (e.g. INVOICE_NOT_FOUND).
+	Name string `json:"name"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("crypto pay api error %d: %s", e.Code, e.Name)
+}
+
+// Is reports whether the error is ErrUnauthorized for rejected tokens.
+func (e *APIError) Is(target error) bool {
+	return target == ErrUnauthorized && e.Code == http.StatusUnauthorized
+}
+
+// maxBodyPreview is the maximum number of body bytes included in a ResponseError.
+const maxBodyPreview = 128
+
+// ResponseError is returned when the API responds with a body that is not JSON (e.g. a plaintext error page of a gateway).
+type ResponseError struct {
+	// HTTP status code of the response.
+	StatusCode int
+
+	// The beginning of the response body.
+	Body string
+}
+
+func newResponseError(status int, body []byte) *ResponseError {
+	if len(body) > maxBodyPreview {
+		body = body[:maxBodyPreview]
+	}
+	return &ResponseError{StatusCode: status, Body: string(body)}
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("unexpected non-JSON response with status %d %s: %q", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Is reports whether the error is ErrUnauthorized for rejected tokens.
+func (e *ResponseError) Is(target error) bool {
+	return target == ErrUnauthorized && e.StatusCode == http.StatusUnauthorized
+}
+
+// UnconfirmedTransferError is returned by ConfirmTransfer when the transfer was not found or has not completed.
+type UnconfirmedTransferError struct {
+	// Spend id of the transfer. It is redacted in the error message.
+	SpendID string
+
+	// Status of the transfer. Empty if the transfer was not found.
+	Status TransferStatus
+}
+
+func (e *UnconfirmedTransferError) Error() string {
+	if len(e.Status) == 0 {
+		return fmt.Sprintf("transfer with spend id %q was not found", redact(e.SpendID))
+	}
+	return fmt.Sprintf("transfer with spend id %q has status %s", redact(e.SpendID), e.Status)
+}
+
+// ItemError is the decoding error of a single list item.
+type ItemError struct {
+	// Index of the item in the response.
+	Index int
+
+	Err error
+}
+
+func (e ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e ItemError) Unwrap() error {
+	return e.Err
+}
+
+// PartialResultError is returned along with the decoded items of a list when Config.PartialResults is set
+// and some of the items could not be decoded.
+type PartialResultError struct {
+	Errors []ItemError
+}
+
+func (e *PartialResultError) Error() string {
+	errs := make([]string, 0, len(e.Errors))
+	for _, ie := range e.Errors {
+		errs = append(errs, ie.Error())
+	}
+	return fmt.Sprintf("failed to decode %d list items: %s", len(e.Errors), strings.Join(errs, "; "))
+}
+
+// wrapError prefixes a non-nil error with the name of the client method that returned it.
+func wrapError(method string, err *error) {
+	if *err != nil {
+		*err = fmt.Errorf("%s: %w", method, *err)
+	}
+}
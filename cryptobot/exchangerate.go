@@ -1,5 +1,11 @@
 package cryptobot
 
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
 type ExchangeRate struct {
 	// Whether or not the received rate is up-to-date.
 	IsValid bool `json:"is_valid"`
@@ -19,3 +25,63 @@ type ExchangeRate struct {
 	// The current rate of the source asset valued in the target currency.
 	Rate string `json:"rate"`
 }
+
+// CryptoRates returns the rates whose source is a cryptocurrency.
+func CryptoRates(rates []ExchangeRate) []ExchangeRate {
+	return filterRates(rates, func(r ExchangeRate) bool { return r.IsCrypto })
+}
+
+// FiatRates returns the rates whose source is a fiat currency.
+func FiatRates(rates []ExchangeRate) []ExchangeRate {
+	return filterRates(rates, func(r ExchangeRate) bool { return r.IsFiat })
+}
+
+// filterRates returns the rates matching keep without modifying the given slice.
+func filterRates(rates []ExchangeRate, keep func(r ExchangeRate) bool) []ExchangeRate {
+	var res []ExchangeRate
+	for _, r := range rates {
+		if keep(r) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// RatesSnapshot holds exchange rates along with the time they were fetched at. The API does not tell when a rate
+// was last updated, so the local fetch time is the best available bound on its staleness.
+type RatesSnapshot struct {
+	Rates []ExchangeRate
+
+	// Local time the rates were received from the API. Cached rates keep the time of their original fetch.
+	FetchedAt time.Time
+}
+
+// Age returns how long before now the rates were fetched. now should come from the same clock as FetchedAt.
+func (s RatesSnapshot) Age(now time.Time) time.Duration {
+	return now.Sub(s.FetchedAt)
+}
+
+// ratesCache holds the most recently fetched exchange rates.
+type ratesCache struct {
+	mu   sync.Mutex
+	snap RatesSnapshot
+}
+
+// get returns a copy of the cached rates if they were fetched less than ttl ago.
+func (c *ratesCache) get(now time.Time, ttl time.Duration) (RatesSnapshot, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.snap.Rates == nil || now.Sub(c.snap.FetchedAt) >= ttl {
+		return RatesSnapshot{}, false
+	}
+
+	return RatesSnapshot{Rates: slices.Clone(c.snap.Rates), FetchedAt: c.snap.FetchedAt}, true
+}
+
+func (c *ratesCache) set(snap RatesSnapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.snap = RatesSnapshot{Rates: slices.Clone(snap.Rates), FetchedAt: snap.FetchedAt}
+}
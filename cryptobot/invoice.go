@@ -3,8 +3,16 @@ package cryptobot
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/mathoc1st/cryptobot-go/cryptobot/qrcode"
 )
 
 type CurrencyType string
@@ -21,13 +29,13 @@ type CryptoAsset string
 // All the available cryptocurrency types.
 const (
 	USDT CryptoAsset = "USDT"
-	TON              = "TON"
-	BTC              = "BTC"
-	ETH              = "ETH"
-	LTC              = "LTC"
-	BNB              = "BNB"
-	TRX              = "TRX"
-	USDC             = "USDC"
+	TON  CryptoAsset = "TON"
+	BTC  CryptoAsset = "BTC"
+	ETH  CryptoAsset = "ETH"
+	LTC  CryptoAsset = "LTC"
+	BNB  CryptoAsset = "BNB"
+	TRX  CryptoAsset = "TRX"
+	USDC CryptoAsset = "USDC"
 )
 
 type CurrencyCode string
@@ -37,60 +45,86 @@ const (
 	// US Dollar
 	USD CurrencyCode = "USD"
 	// Euro
-	EUR = "EUR"
+	EUR CurrencyCode = "EUR"
 	// Russian Ruble
-	RUB = "RUB"
+	RUB CurrencyCode = "RUB"
 	// Belarusian Ruble
-	BYN = "BYN"
+	BYN CurrencyCode = "BYN"
 	// Ukrainian Hryvnia
-	UAH = "UAH"
+	UAH CurrencyCode = "UAH"
 	// British Pound Sterling
-	GBP = "GBP"
+	GBP CurrencyCode = "GBP"
 	// Chinese Yuan
-	CNY = "CNY"
+	CNY CurrencyCode = "CNY"
 	// Kazakhstani Tenge
-	KZT = "KZT"
+	KZT CurrencyCode = "KZT"
 	// Uzbekistani Som
-	UZS = "UZS"
+	UZS CurrencyCode = "UZS"
 	// Georgian Lari
-	GEL = "GEL"
+	GEL CurrencyCode = "GEL"
 	// Turkish Lira
-	TRY = "TRY"
+	TRY CurrencyCode = "TRY"
 	// Armenian Dram
-	AMD = "AMD"
+	AMD CurrencyCode = "AMD"
 	// Thai Baht
-	THB = "THB"
+	THB CurrencyCode = "THB"
 	// Indian Rupee
-	INR = "INR"
+	INR CurrencyCode = "INR"
 	// Brazilian Real
-	BRL = "BRL"
+	BRL CurrencyCode = "BRL"
 	// Indonesian Rupiah
-	IDR = "IDR"
+	IDR CurrencyCode = "IDR"
 	// Azerbaijani Manat
-	AZN = "AZN"
+	AZN CurrencyCode = "AZN"
 	// United Arab Emirates Dirham
-	AED = "AED"
+	AED CurrencyCode = "AED"
 	// Polish Zloty
-	PLN = "PLN"
+	PLN CurrencyCode = "PLN"
 	// Israeli New Shekel
-	ILS = "ILS"
+	ILS CurrencyCode = "ILS"
 )
 
+// SupportedCryptoAssets returns all the available cryptocurrency types.
+func SupportedCryptoAssets() []CryptoAsset {
+	return []CryptoAsset{USDT, TON, BTC, ETH, LTC, BNB, TRX, USDC}
+}
+
+// Stablecoins returns the available cryptocurrency types that are stablecoins.
+func Stablecoins() []CryptoAsset {
+	return []CryptoAsset{USDT, USDC}
+}
+
+// SupportedFiatCurrencies returns all the available fiat currency codes.
+func SupportedFiatCurrencies() []CurrencyCode {
+	return []CurrencyCode{USD, EUR, RUB, BYN, UAH, GBP, CNY, KZT, UZS, GEL, TRY, AMD, THB, INR, BRL, IDR, AZN, AED, PLN, ILS}
+}
+
+// IsKnown reports whether the asset is one of SupportedCryptoAssets. Responses are decoded without an error
+// even when they contain assets added to the API after this version of the library.
+func (a CryptoAsset) IsKnown() bool {
+	return slices.Contains(SupportedCryptoAssets(), a)
+}
+
+// IsKnown reports whether the currency code is one of SupportedFiatCurrencies.
+func (c CurrencyCode) IsKnown() bool {
+	return slices.Contains(SupportedFiatCurrencies(), c)
+}
+
 type InvoiceStatus string
 
 const (
 	InvoicePaid    InvoiceStatus = "paid"
-	InvoiceActive                = "active"
-	InvoiceExpired               = "expired"
+	InvoiceActive  InvoiceStatus = "active"
+	InvoiceExpired InvoiceStatus = "expired"
 )
 
 type ButtonName string
 
 const (
 	ViewItem    ButtonName = "viewItem"
-	OpenChannel            = "openChannel"
-	OpenBot                = "openBot"
-	Callback               = "callback"
+	OpenChannel ButtonName = "openChannel"
+	OpenBot     ButtonName = "openBot"
+	Callback    ButtonName = "callback"
 )
 
 type Invoice struct {
@@ -113,13 +147,13 @@ type Invoice struct {
 	Fiat CurrencyCode `json:"fiat,omitempty"`
 
 	// Amount of the invoice.
-	Amount string `json:"amount"`
+	Amount Amount `json:"amount"`
 
 	// Available only if CurrencyType is fiat and Status is invoicePaid. Cryptocurrency that was used to pay the invoice.
 	PaidAsset CryptoAsset `json:"paid_asset,omitempty"`
 
 	// Available only if CurrencyType is fiat and Status is invoicePaid. Amount of the invoice for which the invoice was paid.
-	PaidAmount string `json:"paid_amount,omitempty"`
+	PaidAmount Amount `json:"paid_amount,omitempty"`
 
 	// Available only if CurrencyType is fiat and Status is invoicePaid. The rate of the PaidAsset value in the fiat currency.
 	PaidFiatRate string `json:"paid_fiat_rate,omitempty"`
@@ -128,7 +162,7 @@ type Invoice struct {
 	FeeAsset string `json:"fee_asset,omitempty"`
 
 	// Available only if Status is invoicePaid. Fee amount that was charged for the invoice.
-	FeeAmount int64 `json:"fee_amount,omitempty"`
+	FeeAmount Amount `json:"fee_amount,omitempty"`
 
 	// URL for the user to pay the invoice using Crypto Bot.
 	BotInvoiceURL string `json:"bot_invoice_url"`
@@ -180,6 +214,372 @@ type Invoice struct {
 
 	// Available only if PaidBtnName was set. URL attached to the button.
 	PaidBtnUrl string `json:"paid_btn_url,omitempty"`
+
+	// Optional. Asset the payment is swapped to once the invoice is paid.
+	SwapTo CryptoAsset `json:"swap_to,omitempty"`
+
+	// Available only if the payment was swapped. Asset the payment was settled in, as opposed to PaidAsset.
+	SwappedTo CryptoAsset `json:"swapped_to,omitempty"`
+
+	// Available only if the payment was swapped. Amount received in SwappedTo after the swap.
+	SwappedOutput Amount `json:"swapped_output,omitempty"`
+}
+
+// NetAmount returns the amount received for a paid invoice after the fee, in the asset it was paid with.
+// It returns an error when the invoice is unpaid or the fee was charged in a different asset.
+func (i Invoice) NetAmount() (Amount, error) {
+	if i.Status != InvoicePaid {
+		return "", fmt.Errorf("invoice %d is not paid", i.ID)
+	}
+
+	asset, gross := i.CryptoAsset, i.Amount
+	if i.CurrencyType == Fiat {
+		asset = i.PaidAsset
+	}
+	if len(i.PaidAmount) != 0 {
+		gross = i.PaidAmount
+	}
+
+	if len(i.FeeAsset) != 0 && i.FeeAsset != string(asset) {
+		return "", fmt.Errorf("fee was charged in %s, but the invoice was paid in %s", i.FeeAsset, asset)
+	}
+
+	g, err := parseAmount(string(gross))
+	if err != nil {
+		return "", err
+	}
+
+	fee := new(big.Rat)
+	if len(i.FeeAmount) != 0 {
+		if fee, err = parseAmount(string(i.FeeAmount)); err != nil {
+			return "", err
+		}
+	}
+
+	prec := max(decimalPlaces(string(gross)), decimalPlaces(string(i.FeeAmount)))
+
+	return Amount(new(big.Rat).Sub(g, fee).FloatString(prec)), nil
+}
+
+// String formats the invoice with its hash and pay URLs redacted, since they grant access to the invoice.
+func (i Invoice) String() string {
+	type invoice Invoice
+
+	r := invoice(i)
+	r.Hash = redact(r.Hash)
+	r.BotInvoiceURL = redactURL(r.BotInvoiceURL)
+	r.MiniAppInvoiceURL = redactURL(r.MiniAppInvoiceURL)
+	r.WebAppInvoiceURL = redactURL(r.WebAppInvoiceURL)
+
+	return fmt.Sprintf("%+v", r)
+}
+
+// HashFromURL extracts the invoice hash from BotInvoiceURL, falling back to MiniAppInvoiceURL when it is empty.
+func (i Invoice) HashFromURL() (string, error) {
+	if len(i.BotInvoiceURL) == 0 {
+		return hashFromURL(i.MiniAppInvoiceURL)
+	}
+	return hashFromURL(i.BotInvoiceURL)
+}
+
+// hashFromURL extracts the hash from a bot link, either from its start parameter (e.g. ?start=IVcKhSGh244v)
+// or from its startapp parameter without the type prefix (e.g. ?startapp=invoice-IVcKhSGh244v).
+func hashFromURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("malformed url: %w", err)
+	}
+	if len(u.Host) == 0 {
+		return "", fmt.Errorf("url %q is not absolute", raw)
+	}
+
+	q := u.Query()
+
+	if app := q.Get("startapp"); len(app) != 0 {
+		_, hash, ok := strings.Cut(app, "-")
+		if !ok || len(hash) == 0 {
+			return "", fmt.Errorf("url %q has a malformed startapp parameter", raw)
+		}
+		return hash, nil
+	}
+
+	if hash := q.Get("start"); len(hash) != 0 {
+		return hash, nil
+	}
+
+	return "", fmt.Errorf("url %q has no start parameter", raw)
+}
+
+// QRCodeScale is the size of a QR code module in pixels used by the invoice QR code helpers.
+const QRCodeScale = 8
+
+// QRCode returns a PNG image of a QR code encoding BotInvoiceURL.
+func (i Invoice) QRCode() ([]byte, error) {
+	if len(i.BotInvoiceURL) == 0 {
+		return nil, errors.New("invoice has no bot invoice url")
+	}
+	return qrcode.PNG([]byte(i.BotInvoiceURL), QRCodeScale)
+}
+
+// MiniAppQRCode returns a PNG image of a QR code encoding MiniAppInvoiceURL.
+func (i Invoice) MiniAppQRCode() ([]byte, error) {
+	if len(i.MiniAppInvoiceURL) == 0 {
+		return nil, errors.New("invoice has no mini app invoice url")
+	}
+	return qrcode.PNG([]byte(i.MiniAppInvoiceURL), QRCodeScale)
+}
+
+// PaymentMessage returns a human-readable description of the invoice for posting alongside a pay button,
+// and the best available pay URL preferring MiniAppInvoiceURL, then WebAppInvoiceURL, then BotInvoiceURL.
+// The URL is empty when the invoice has none of them.
+func (i Invoice) PaymentMessage() (text string, buttonURL string) {
+	var amount string
+	if i.CurrencyType == Fiat {
+		amount = FormatFiat(string(i.Amount), i.Fiat)
+	} else {
+		amount = FormatAmount(string(i.Amount), i.CryptoAsset)
+	}
+
+	text = fmt.Sprintf("Invoice #%d: %s", i.ID, amount)
+	if len(i.Description) != 0 {
+		text += "\n" + i.Description
+	}
+
+	for _, u := range []string{i.MiniAppInvoiceURL, i.WebAppInvoiceURL, i.BotInvoiceURL} {
+		if len(u) != 0 {
+			return text, u
+		}
+	}
+
+	return text, ""
+}
+
+// TimeToPayment returns how long it took for the invoice to be paid after it was created.
+// It reports false when the invoice is unpaid or its timestamps cannot be parsed.
+func (i Invoice) TimeToPayment() (time.Duration, bool) {
+	if i.Status != InvoicePaid {
+		return 0, false
+	}
+
+	created, err := parseDate(i.CreatedAt)
+	if err != nil {
+		return 0, false
+	}
+
+	paid, err := parseDate(i.PaidAt)
+	if err != nil {
+		return 0, false
+	}
+
+	return paid.Sub(created), true
+}
+
+// ExpiresAt returns the expiration date of the invoice. It reports false when the invoice was created without
+// ExpiresIn or the date cannot be parsed.
+func (i Invoice) ExpiresAt() (time.Time, bool) {
+	if len(i.ExpirationDate) == 0 {
+		return time.Time{}, false
+	}
+
+	expires, err := parseDate(i.ExpirationDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return expires, true
+}
+
+// IsPayable reports whether the invoice can still be paid at the given time: it is active and its expiration
+// date, if any, is in the future. The status may lag behind the clock, so an expired invoice can still be active.
+func (i Invoice) IsPayable(now time.Time) bool {
+	if i.Status != InvoiceActive {
+		return false
+	}
+	if len(i.ExpirationDate) == 0 {
+		return true
+	}
+
+	expires, err := parseDate(i.ExpirationDate)
+	if err != nil {
+		return false
+	}
+
+	return now.Before(expires)
+}
+
+// renewal reconstructs the new invoice the given invoice was created from.
+func renewal(old Invoice) (NewInvoice, error) {
+	in := NewInvoice{
+		CurrencyType:   old.CurrencyType,
+		Amount:         string(old.Amount),
+		Description:    old.Description,
+		HiddenMessage:  old.HiddenMessage,
+		PaidBtnName:    old.PaidBtnName,
+		PaidBtnUrl:     old.PaidBtnUrl,
+		Payload:        old.Payload,
+		AllowComments:  old.AllowComments,
+		AllowAnonymous: old.AllowAnonymous,
+	}
+
+	if old.CurrencyType == Fiat {
+		in.Fiat = old.Fiat
+		in.AcceptedCryptoAssets = old.AcceptedCryptoAssets
+		in.SwapTo = old.SwapTo
+	} else {
+		in.CryptoAsset = old.CryptoAsset
+	}
+
+	if len(old.ExpirationDate) != 0 {
+		created, err := parseDate(old.CreatedAt)
+		if err != nil {
+			return NewInvoice{}, fmt.Errorf("failed to parse the creation date: %w", err)
+		}
+
+		expires, err := parseDate(old.ExpirationDate)
+		if err != nil {
+			return NewInvoice{}, fmt.Errorf("failed to parse the expiration date: %w", err)
+		}
+
+		in.ExpiresIn = int64(expires.Sub(created).Round(time.Second).Seconds())
+		if in.ExpiresIn < 1 || in.ExpiresIn > maxExpiresIn {
+			return NewInvoice{}, fmt.Errorf("expiration time of %d seconds is outside the 1-2678400 second range", in.ExpiresIn)
+		}
+	}
+
+	return in, nil
+}
+
+// parseDate parses an ISO 8601 date returned by the API.
+func parseDate(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339, s)
+}
+
+// MarshalJSON encodes the invoice with the API field names. Unlike the API, every field is always present,
+// so stored invoices have a stable shape and decode back into identical invoices.
+func (i Invoice) MarshalJSON() ([]byte, error) {
+	type invoice Invoice
+
+	return marshal(struct {
+		invoice
+		CryptoAsset          CryptoAsset   `json:"asset"`
+		AcceptedCryptoAssets []CryptoAsset `json:"accepted_assets"`
+		Fiat                 CurrencyCode  `json:"fiat"`
+		PaidAsset            CryptoAsset   `json:"paid_asset"`
+		PaidAmount           Amount        `json:"paid_amount"`
+		PaidFiatRate         string        `json:"paid_fiat_rate"`
+		FeeAsset             string        `json:"fee_asset"`
+		FeeAmount            Amount        `json:"fee_amount"`
+		Description          string        `json:"description"`
+		PaidUSDRate          string        `json:"paid_usd_rate"`
+		ExpirationDate       string        `json:"expiration_date"`
+		PaidAt               string        `json:"paid_at"`
+		Comment              string        `json:"comment"`
+		HiddenMessage        string        `json:"hidden_message"`
+		Payload              string        `json:"payload"`
+		PaidBtnName          ButtonName    `json:"paid_btn_name"`
+		PaidBtnUrl           string        `json:"paid_btn_url"`
+		SwapTo               CryptoAsset   `json:"swap_to"`
+		SwappedTo            CryptoAsset   `json:"swapped_to"`
+		SwappedOutput        Amount        `json:"swapped_output"`
+	}{
+		invoice:              invoice(i),
+		CryptoAsset:          i.CryptoAsset,
+		AcceptedCryptoAssets: i.AcceptedCryptoAssets,
+		Fiat:                 i.Fiat,
+		PaidAsset:            i.PaidAsset,
+		PaidAmount:           i.PaidAmount,
+		PaidFiatRate:         i.PaidFiatRate,
+		FeeAsset:             i.FeeAsset,
+		FeeAmount:            i.FeeAmount,
+		Description:          i.Description,
+		PaidUSDRate:          i.PaidUSDRate,
+		ExpirationDate:       i.ExpirationDate,
+		PaidAt:               i.PaidAt,
+		Comment:              i.Comment,
+		HiddenMessage:        i.HiddenMessage,
+		Payload:              i.Payload,
+		PaidBtnName:          i.PaidBtnName,
+		PaidBtnUrl:           i.PaidBtnUrl,
+		SwapTo:               i.SwapTo,
+		SwappedTo:            i.SwappedTo,
+		SwappedOutput:        i.SwappedOutput,
+	})
+}
+
+// UnmarshalJSON decodes an invoice. The accepted assets are accepted both as a JSON array and as a comma-separated string.
+func (i *Invoice) UnmarshalJSON(data []byte) error {
+	type invoice Invoice
+
+	var tmp struct {
+		invoice
+		AcceptedCryptoAssets json.RawMessage `json:"accepted_assets"`
+		AllowComments        flexBool        `json:"allow_comments"`
+		AllowAnonymous       flexBool        `json:"allow_anonymous"`
+		PaidAnonymously      flexBool        `json:"paid_anonymously"`
+	}
+
+	if err := unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	*i = Invoice(tmp.invoice)
+
+	as, err := parseCryptoAssets(tmp.AcceptedCryptoAssets)
+	if err != nil {
+		return fmt.Errorf("failed to decode accepted_assets: %w", err)
+	}
+
+	i.AcceptedCryptoAssets = as
+	i.AllowComments = bool(tmp.AllowComments)
+	i.AllowAnonymous = bool(tmp.AllowAnonymous)
+	i.PaidAnonymously = bool(tmp.PaidAnonymously)
+
+	return nil
+}
+
+// flexBool is a boolean decoded from either a JSON boolean or the strings "true" and "false",
+// which some responses send instead.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("boolean should be true or false, got %s", data)
+	}
+	return nil
+}
+
+// parseCryptoAssets decodes a list of crypto assets from either a JSON array or a comma-separated JSON string.
+func parseCryptoAssets(data json.RawMessage) ([]CryptoAsset, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	if data[0] == '[' {
+		var as []CryptoAsset
+		if err := unmarshal(data, &as); err != nil {
+			return nil, err
+		}
+		return as, nil
+	}
+
+	var str string
+	if err := unmarshal(data, &str); err != nil {
+		return nil, err
+	}
+
+	var as []CryptoAsset
+	for _, a := range strings.Split(str, ",") {
+		if a = strings.TrimSpace(a); len(a) != 0 {
+			as = append(as, CryptoAsset(a))
+		}
+	}
+
+	return as, nil
 }
 
 type NewInvoice struct {
@@ -195,6 +595,13 @@ type NewInvoice struct {
 	// Should be set if CurrencyType is fiat. Cryptocurrency types that can be used to pay the invoice with.
 	AcceptedCryptoAssets []CryptoAsset
 
+	// Optional. Available only if the CurrencyType is fiat. When AcceptedCryptoAssets is empty,
+	// CreateInvoice populates it with every blockchain asset returned by getCurrencies.
+	AcceptAllAssets bool
+
+	// Optional. Available only if AcceptAllAssets is set. Limits the populated assets to stablecoins.
+	StablecoinsOnly bool
+
 	// Amount the user will have to pay.
 	Amount string
 
@@ -207,7 +614,8 @@ type NewInvoice struct {
 	// Optional. Type of the button that will be shown to the user once the invoice is paid.
 	PaidBtnName ButtonName
 
-	// Should be set if PaidBtnName is set. URL that will be attached to the button.
+	// Should be set if PaidBtnName is set. URL that will be attached to the button. It should be an absolute
+	// http(s) URL, except for the callback button, whose URL is passed to the bot as is.
 	PaidBtnUrl string
 
 	// Optional. Payload to attach to the invoice. 4096 characters max.
@@ -221,6 +629,76 @@ type NewInvoice struct {
 
 	// Optional. Expiration time of the invoice in seconds. Values between 1-2678400 are accepted.
 	ExpiresIn int64
+
+	// Optional. Available only if the CurrencyType is fiat. Stablecoin the payment is swapped to, so the price
+	// can be shown in fiat, paid with any of AcceptedCryptoAssets and settled in a stable asset.
+	SwapTo CryptoAsset
+
+	// Optional. Absolute expiration time of the invoice, an alternative to ExpiresIn. It is converted to expires_in
+	// when the invoice is marshalled, capped at 2678400 seconds. It has to be in the future.
+	ExpiresAt time.Time
+}
+
+// maxExpiresIn is the longest expiration time of an invoice in seconds.
+const maxExpiresIn = 2678400
+
+// timeNow is used by NewInvoice.MarshalJSON to read the current time, so it can be replaced in tests.
+var timeNow = time.Now
+
+// expiresIn converts an absolute expiration time into the seconds left at now, capped at maxExpiresIn.
+func expiresIn(now, at time.Time) (int64, error) {
+	if !at.After(now) {
+		return 0, fmt.Errorf("ExpiresAt %s is not in the future", at.Format(time.RFC3339))
+	}
+
+	secs := int64(math.Ceil(at.Sub(now).Seconds()))
+	return min(secs, maxExpiresIn), nil
+}
+
+// SetPayload encodes v as JSON and stores it as the invoice payload.
+// The encoded payload cannot exceed 4096 characters.
+func (in *NewInvoice) SetPayload(v any) error {
+	data, err := marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode the payload: %w", err)
+	}
+	if len(data) > 4096 {
+		return errors.New("encoded Payload should not exceed 4096 characters")
+	}
+
+	in.Payload = string(data)
+
+	return nil
+}
+
+// Payload decodes the JSON payload of the invoice into T. It is the counterpart of NewInvoice.SetPayload.
+func Payload[T any](i Invoice) (T, error) {
+	var v T
+
+	if err := unmarshal([]byte(i.Payload), &v); err != nil {
+		return v, fmt.Errorf("failed to decode the payload: %w", err)
+	}
+
+	return v, nil
+}
+
+// DiffInvoiceStatuses returns the old and new status of every invoice of curr whose status differs from the one in prev,
+// keyed by invoice id. Invoices missing from prev are reported with an empty old status, while invoices missing from
+// curr are left out, since they may just be on another page.
+func DiffInvoiceStatuses(prev, curr []Invoice) map[int64][2]InvoiceStatus {
+	old := make(map[int64]InvoiceStatus, len(prev))
+	for _, in := range prev {
+		old[in.ID] = in.Status
+	}
+
+	diff := make(map[int64][2]InvoiceStatus)
+	for _, in := range curr {
+		if st := old[in.ID]; st != in.Status {
+			diff[in.ID] = [2]InvoiceStatus{st, in.Status}
+		}
+	}
+
+	return diff
 }
 
 type tempNewInvoice struct {
@@ -237,16 +715,32 @@ type tempNewInvoice struct {
 	AllowComments        bool         `json:"allow_comments"`
 	AllowAnonymous       bool         `json:"allow_anonymous"`
 	ExpiresIn            int64        `json:"expires_in,omitempty"`
+	SwapTo               CryptoAsset  `json:"swap_to,omitempty"`
 }
 
 func (in NewInvoice) MarshalJSON() ([]byte, error) {
+	if in.ExpiresIn != 0 && !in.ExpiresAt.IsZero() {
+		return nil, errors.New("ExpiresIn and ExpiresAt cannot both be set")
+	}
+
+	expires := in.ExpiresIn
+	if !in.ExpiresAt.IsZero() {
+		var err error
+		if expires, err = expiresIn(timeNow(), in.ExpiresAt); err != nil {
+			return nil, err
+		}
+	}
+
 	var as []string
 
-	for _, a := range in.AcceptedCryptoAssets {
-		as = append(as, string(a))
+	// accepted_assets only applies to fiat invoices and is left out entirely otherwise.
+	if in.CurrencyType == Fiat {
+		for _, a := range in.AcceptedCryptoAssets {
+			as = append(as, string(a))
+		}
 	}
 
-	return json.Marshal(tempNewInvoice{
+	return marshal(tempNewInvoice{
 		CurrencyType:         in.CurrencyType,
 		CryptoAsset:          in.CryptoAsset,
 		Fiat:                 in.Fiat,
@@ -259,7 +753,8 @@ func (in NewInvoice) MarshalJSON() ([]byte, error) {
 		Payload:              in.Payload,
 		AllowComments:        in.AllowComments,
 		AllowAnonymous:       in.AllowAnonymous,
-		ExpiresIn:            in.ExpiresIn,
+		ExpiresIn:            expires,
+		SwapTo:               in.SwapTo,
 	})
 }
 
@@ -281,6 +776,38 @@ type InvoiceOptions struct {
 
 	// Optional. Number of invoices to be returned. Values between 1-1000 are accepted. Defaults to 100.
 	Count int64 `json:"count,omitempty"`
+
+	// Optional. Order of the returned invoices. The API does not sort, so the invoices of the page are sorted
+	// client-side after they are fetched. Unsorted when empty.
+	Order Order `json:"-"`
+}
+
+// Order is the order in which invoices are returned.
+type Order string
+
+const (
+	// OrderCreatedDesc sorts invoices from the newest to the oldest.
+	OrderCreatedDesc Order = "created_desc"
+	// OrderCreatedAsc sorts invoices from the oldest to the newest.
+	OrderCreatedAsc Order = "created_asc"
+)
+
+// sortInvoices sorts the invoices by creation date in the given order. Invoices whose creation date cannot be parsed
+// are treated as the oldest, and invoices created at the same time keep their relative order.
+func sortInvoices(ins []Invoice, order Order) {
+	if len(order) == 0 {
+		return
+	}
+
+	slices.SortStableFunc(ins, func(a, b Invoice) int {
+		ta, _ := parseDate(a.CreatedAt)
+		tb, _ := parseDate(b.CreatedAt)
+
+		if order == OrderCreatedDesc {
+			return tb.Compare(ta)
+		}
+		return ta.Compare(tb)
+	})
 }
 
 type tempInOps struct {
@@ -293,13 +820,13 @@ type tempInOps struct {
 }
 
 func (no InvoiceOptions) MarshalJSON() ([]byte, error) {
-	ids := make([]string, len(no.InvoiceIDs))
+	ids := make([]string, 0, len(no.InvoiceIDs))
 
 	for _, id := range no.InvoiceIDs {
 		ids = append(ids, strconv.FormatInt(id, 10))
 	}
 
-	return json.Marshal(&tempInOps{
+	return marshal(&tempInOps{
 		CryptoAsset:  string(no.CryptoAsset),
 		FiatCurrency: string(no.Fiat),
 		InvoiceIDs:   strings.Join(ids, ","),
@@ -323,18 +850,51 @@ func validateNewInvoice(in NewInvoice) error {
 	if in.CurrencyType == Fiat && len(in.Fiat) == 0 {
 		errs = append(errs, errors.New("FiatCurrency cannot be empty"))
 	}
+	if in.CurrencyType == Crypto && len(in.Fiat) != 0 {
+		errs = append(errs, errors.New("Fiat cannot be set when CurrencyType is crypto"))
+	}
+	if in.CurrencyType == Crypto && len(in.AcceptedCryptoAssets) != 0 {
+		errs = append(errs, errors.New("AcceptedCryptoAssets cannot be set when CurrencyType is crypto"))
+	}
+	if in.CurrencyType != Fiat && in.AcceptAllAssets {
+		errs = append(errs, errors.New("AcceptAllAssets can only be set when CurrencyType is fiat"))
+	}
+	if in.StablecoinsOnly && !in.AcceptAllAssets {
+		errs = append(errs, errors.New("StablecoinsOnly can only be set together with AcceptAllAssets"))
+	}
+	if in.CurrencyType == Fiat && len(in.CryptoAsset) != 0 {
+		errs = append(errs, errors.New("CryptoAsset cannot be set when CurrencyType is fiat"))
+	}
 	if len(in.Amount) == 0 {
 		errs = append(errs, errors.New("Amount cannot be empty"))
+	} else if decimals, ok := invoiceDecimals(in); ok {
+		if err := validateAmount(in.Amount, decimals); err != nil {
+			errs = append(errs, fmt.Errorf("Amount is invalid: %w", err))
+		}
 	}
 	if len(in.PaidBtnName) != 0 && len(in.PaidBtnUrl) == 0 {
 		errs = append(errs, errors.New("PaidBtnUrl cannot be empty"))
+	} else if len(in.PaidBtnName) != 0 && in.PaidBtnName != Callback && !isAbsoluteURL(in.PaidBtnUrl) {
+		errs = append(errs, fmt.Errorf("PaidBtnUrl should be an absolute http(s) url for the %s button", in.PaidBtnName))
 	}
 	if len(in.Payload) > 4096 {
 		errs = append(errs, errors.New("Payload should not exceed 4096 characters"))
 	}
-	if in.ExpiresIn != 0 && (in.ExpiresIn < 1 || in.ExpiresIn > 2678400) {
+	if in.ExpiresIn != 0 && (in.ExpiresIn < 1 || in.ExpiresIn > maxExpiresIn) {
 		errs = append(errs, errors.New("expiration time should be within 1-2678400 second range"))
 	}
+	if in.ExpiresIn != 0 && !in.ExpiresAt.IsZero() {
+		errs = append(errs, errors.New("ExpiresIn and ExpiresAt cannot both be set"))
+	}
+	if !in.ExpiresAt.IsZero() && !in.ExpiresAt.After(timeNow()) {
+		errs = append(errs, errors.New("ExpiresAt should be in the future"))
+	}
+	if len(in.SwapTo) != 0 && in.CurrencyType != Fiat {
+		errs = append(errs, errors.New("SwapTo can only be set when CurrencyType is fiat"))
+	}
+	if len(in.SwapTo) != 0 && !slices.Contains(Stablecoins(), in.SwapTo) {
+		errs = append(errs, fmt.Errorf("SwapTo should be a stablecoin, got %s", in.SwapTo))
+	}
 
 	if len(errs) == 0 {
 		return nil
@@ -343,6 +903,24 @@ func validateNewInvoice(in NewInvoice) error {
 	return errors.Join(errs...)
 }
 
+// isAbsoluteURL reports whether s is an absolute http(s) url.
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && len(u.Host) != 0
+}
+
+// invoiceDecimals returns the decimal precision of the invoice amount. It reports false for unknown assets.
+func invoiceDecimals(in NewInvoice) (int, bool) {
+	switch in.CurrencyType {
+	case Fiat:
+		return fiatDecimals, true
+	case Crypto:
+		decimals, ok := assetDecimals[in.CryptoAsset]
+		return decimals, ok
+	}
+	return 0, false
+}
+
 func validateInvoiceOptions(inop InvoiceOptions) error {
 	var errs []error
 	if inop.Offset < 0 {
@@ -351,6 +929,9 @@ func validateInvoiceOptions(inop InvoiceOptions) error {
 	if inop.Count != 0 && (inop.Count < 1 || inop.Count > 1000) {
 		errs = append(errs, errors.New("Count needs to be within 1-1000 record range"))
 	}
+	if len(inop.Order) != 0 && inop.Order != OrderCreatedDesc && inop.Order != OrderCreatedAsc {
+		errs = append(errs, fmt.Errorf("Order %q is not supported", inop.Order))
+	}
 
 	if len(errs) == 0 {
 		return nil
@@ -0,0 +1,76 @@
+package cryptobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// lifecycle tracks the in-flight requests of a client so they can be cancelled on shutdown.
+// It is shared by the copies returned from WithClient.
+type lifecycle struct {
+	root   context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newLifecycle() *lifecycle {
+	root, cancel := context.WithCancel(context.Background())
+	return &lifecycle{root: root, cancel: cancel}
+}
+
+// begin registers a request and returns its context, cancelled either with ctx or on shutdown.
+// The returned function must be called once the request is done.
+func (l *lifecycle) begin(ctx context.Context) (context.Context, func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, nil, ErrClientClosed
+	}
+
+	l.wg.Add(1)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(l.root, func() { cancel(ErrClientClosed) })
+
+	return ctx, func() {
+		stop()
+		cancel(nil)
+		l.wg.Done()
+	}, nil
+}
+
+// shutdown rejects new requests, cancels the in-flight ones and waits for them to return until ctx is done.
+func (l *lifecycle) shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+
+	l.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closedError makes a request error caused by a shutdown match ErrClientClosed.
+func closedError(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrClientClosed) {
+		return fmt.Errorf("%w: %w", ErrClientClosed, err)
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package cryptobot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying a correlation id, which requests made with the context (e.g. by
+// CreateInvoiceContext) add to their log entries and errors, so e.g. a checkout can be traced from invoice creation
+// to its webhook update.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation id stored in the context by WithCorrelationID.
+func CorrelationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationKey{}).(string)
+	return id, ok && len(id) != 0
+}
+
+// logRequest logs a single request attempt at debug level, or at warn level when it failed or the API responded
+// with an error status.
+func (cb cryptobot) logRequest(ctx context.Context, name string, attempt, status int, took time.Duration, err error) {
+	if cb.logger == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", name),
+		slog.Int("attempt", attempt),
+		slog.Int("status", status),
+		slog.Duration("duration", took),
+	}
+	if id, ok := CorrelationIDFromContext(ctx); ok {
+		attrs = append(attrs, slog.String("correlation_id", id))
+	}
+
+	level := slog.LevelDebug
+	if err != nil || status >= 400 {
+		level = slog.LevelWarn
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	cb.logger.LogAttrs(ctx, level, "crypto pay request", attrs...)
+}
@@ -0,0 +1,206 @@
+// Package qrcode implements a minimal QR code encoder for payment links.
+// It only supports byte mode with error correction level M and versions 1-10 (up to 213 bytes),
+// which is plenty for the URLs returned by the Crypto Pay API.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// QuietZone is the width of the light border around the symbol in modules.
+const QuietZone = 4
+
+// Error correction block structure of versions 1-10 at level M.
+type blockInfo struct {
+	ecPerBlock int
+	g1Blocks   int
+	g1Data     int
+	g2Blocks   int
+	g2Data     int
+}
+
+var blocksM = [...]blockInfo{
+	1:  {10, 1, 16, 0, 0},
+	2:  {16, 1, 28, 0, 0},
+	3:  {26, 1, 44, 0, 0},
+	4:  {18, 2, 32, 0, 0},
+	5:  {24, 2, 43, 0, 0},
+	6:  {16, 4, 27, 0, 0},
+	7:  {18, 4, 31, 0, 0},
+	8:  {22, 2, 38, 2, 39},
+	9:  {22, 3, 36, 2, 37},
+	10: {26, 4, 43, 1, 44},
+}
+
+// Centers of the alignment patterns of versions 1-10.
+var alignment = [...][]int{
+	1:  nil,
+	2:  {6, 18},
+	3:  {6, 22},
+	4:  {6, 26},
+	5:  {6, 30},
+	6:  {6, 34},
+	7:  {6, 22, 38},
+	8:  {6, 24, 42},
+	9:  {6, 26, 46},
+	10: {6, 28, 50},
+}
+
+// Format bits of error correction level M.
+const levelM = 0
+
+func (b blockInfo) dataCodewords() int {
+	return b.g1Blocks*b.g1Data + b.g2Blocks*b.g2Data
+}
+
+// Encode encodes data into a QR code and returns its modules indexed by row and column. True is a dark module.
+func Encode(data []byte) ([][]bool, error) {
+	version := 0
+	for v := 1; v < len(blocksM); v++ {
+		if 4+countBits(v)+8*len(data) <= blocksM[v].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("data is too long to be encoded")
+	}
+
+	q := newSymbol(version)
+	q.drawFunctionPatterns()
+	q.drawCodewords(interleave(version, dataCodewords(version, data)))
+
+	best, penalty := 0, -1
+	for mask := range 8 {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); penalty < 0 || p < penalty {
+			best, penalty = mask, p
+		}
+		q.applyMask(mask)
+	}
+
+	q.applyMask(best)
+	q.drawFormatBits(best)
+
+	return q.modules, nil
+}
+
+// PNG encodes data into a QR code and renders it as a PNG image. Every module is scale pixels wide
+// and the symbol is surrounded by a quiet zone.
+func PNG(data []byte, scale int) ([]byte, error) {
+	if scale < 1 {
+		return nil, errors.New("scale should be at least 1")
+	}
+
+	modules, err := Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (len(modules) + 2*QuietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+
+	for y := range size {
+		for x := range size {
+			row, col := y/scale-QuietZone, x/scale-QuietZone
+			dark := row >= 0 && col >= 0 && row < len(modules) && col < len(modules) && modules[row][col]
+			if dark {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// countBits returns the length of the byte mode character count indicator.
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// dataCodewords builds the byte mode segment, terminates and pads it to the capacity of the version.
+func dataCodewords(version int, data []byte) []byte {
+	capacity := blocksM[version].dataCodewords()
+
+	var bb bitBuffer
+	bb.append(0b0100, 4)
+	bb.append(len(data), countBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	bb.append(0, min(4, capacity*8-len(bb)))
+	bb.append(0, (8-len(bb)%8)%8)
+
+	for pad := 0xEC; len(bb) < capacity*8; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	return bb.bytes()
+}
+
+// interleave splits the data codewords into blocks, appends error correction and interleaves the result.
+func interleave(version int, data []byte) []byte {
+	info := blocksM[version]
+	divisor := rsDivisor(info.ecPerBlock)
+
+	var blocks, ecs [][]byte
+	for i := range info.g1Blocks + info.g2Blocks {
+		n := info.g1Data
+		if i >= info.g1Blocks {
+			n = info.g2Data
+		}
+		blocks = append(blocks, data[:n])
+		ecs = append(ecs, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+
+	var res []byte
+	for i := range max(info.g1Data, info.g2Data) {
+		for _, b := range blocks {
+			if i < len(b) {
+				res = append(res, b[i])
+			}
+		}
+	}
+	for i := range info.ecPerBlock {
+		for _, ec := range ecs {
+			res = append(res, ec[i])
+		}
+	}
+
+	return res
+}
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, v>>i&1 == 1)
+	}
+}
+
+func (bb bitBuffer) bytes() []byte {
+	res := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			res[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return res
+}
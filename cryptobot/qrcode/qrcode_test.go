@@ -0,0 +1,146 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// HELLO WORLD encoded at 1-M.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := rsRemainder(data, rsDivisor(10)); !slices.Equal(got, want) {
+		t.Errorf("got error correction %v, want %v", got, want)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	want := []int{
+		0b101010000010010,
+		0b101000100100101,
+		0b101111001111100,
+		0b101101101001011,
+		0b100010111111001,
+		0b100000011001110,
+		0b100111110010111,
+		0b100101010100000,
+	}
+
+	for mask, w := range want {
+		if got := formatBits(levelM, mask); got != w {
+			t.Errorf("got format bits %015b for mask %d, want %015b", got, mask, w)
+		}
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	if got := versionBits(7); got != 0b000111110010010100 {
+		t.Errorf("got version bits %018b, want 000111110010010100", got)
+	}
+}
+
+func TestDataCodewords(t *testing.T) {
+	got := dataCodewords(1, []byte("hi"))
+	want := []byte{0x40, 0x26, 0x86, 0x90, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("got data codewords % x, want % x", got, want)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tdata := []struct {
+		data string
+		size int
+	}{
+		{data: "hi", size: 21},
+		{data: "https://t.me/CryptoBot?start=IVcKhSGh244v", size: 29},
+		{data: "https://t.me/CryptoBot/app?startapp=invoice-IVcKhSGh244v&mode=compact", size: 37},
+		{data: strings.Repeat("a", 213), size: 57},
+	}
+
+	for _, test := range tdata {
+		modules, err := Encode([]byte(test.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(modules) != test.size {
+			t.Errorf("got size %d for %d bytes, want %d", len(modules), len(test.data), test.size)
+		}
+
+		// Finder pattern corners and the dark module.
+		size := len(modules)
+		for _, m := range [][2]int{{0, 0}, {0, size - 1}, {size - 1, 0}, {3, 3}, {size - 8, 8}} {
+			if !modules[m[0]][m[1]] {
+				t.Errorf("module %v should be dark", m)
+			}
+		}
+	}
+
+	if _, err := Encode(bytes.Repeat([]byte("a"), 214)); err == nil {
+		t.Error("expected an error for data exceeding the capacity")
+	}
+}
+
+// The golden matrices in testdata were generated with the QRCode library of Kazuhiko Arase (as vendored by the
+// qrcode-terminal npm package) at error correction level M. That library scores the masks differently, so every
+// matrix was taken with the mask Encode selects, which it matches module for module.
+func TestEncodeGolden(t *testing.T) {
+	tdata := []struct {
+		file string
+		data string
+	}{
+		{file: "version1.txt", data: "hi"},
+		{file: "version3.txt", data: "https://t.me/CryptoBot?start=IVcKhSGh244v"},
+		{file: "version5.txt", data: "https://t.me/CryptoBot/app?startapp=invoice-IVcKhSGh244v&mode=compact"},
+		{file: "version8.txt", data: "https://testnet-app.send.tg/invoices/IVfkD3Rj8bQw?utm_source=cryptobot-go&utm_medium=qr&utm_campaign=checkout-2026-10&ref=shop-42"},
+		{file: "version10.txt", data: "https://t.me/CryptoBot/app?startapp=invoice-IVcKhSGh244v&mode=compact&payload=order-2048-customer-7f3a9c1e-shipping-express-notes-leave-at-the-door-please-ring-twice-and-wait-for-the-courier-to-confirm-x"},
+	}
+
+	for _, test := range tdata {
+		golden, err := os.ReadFile(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Fields(string(golden))
+
+		modules, err := Encode([]byte(test.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(modules) != len(want) {
+			t.Errorf("%s: got size %d, want %d", test.file, len(modules), len(want))
+			continue
+		}
+
+		for row, line := range want {
+			for col, c := range line {
+				if modules[row][col] != (c == '#') {
+					t.Errorf("%s: module (%d, %d) differs from the reference", test.file, row, col)
+				}
+			}
+		}
+	}
+}
+
+func TestPNG(t *testing.T) {
+	data, err := PNG([]byte("https://t.me/CryptoBot?start=IVcKhSGh244v"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := img.Bounds().Dx(), (29+2*QuietZone)*4; got != want {
+		t.Errorf("got width %d, want %d", got, want)
+	}
+}
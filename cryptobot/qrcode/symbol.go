@@ -0,0 +1,280 @@
+package qrcode
+
+// symbol is a QR code under construction.
+type symbol struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func newSymbol(version int) *symbol {
+	size := version*4 + 17
+
+	q := &symbol{version: version, size: size}
+	q.modules = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for i := range size {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	return q
+}
+
+func (q *symbol) set(row, col int, dark bool) {
+	q.modules[row][col] = dark
+	q.function[row][col] = true
+}
+
+func (q *symbol) drawFunctionPatterns() {
+	for i := range q.size {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	q.drawFinder(3, 3)
+	q.drawFinder(3, q.size-4)
+	q.drawFinder(q.size-4, 3)
+
+	pos := alignment[q.version]
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			q.drawAlignment(pos[i], pos[j])
+		}
+	}
+
+	// Reserve the format areas, they are drawn once the mask is chosen.
+	q.drawFormatBits(0)
+	q.drawVersion()
+}
+
+// drawFinder draws a finder pattern centered at the given module, including its separator.
+func (q *symbol) drawFinder(row, col int) {
+	for dr := -4; dr <= 4; dr++ {
+		for dc := -4; dc <= 4; dc++ {
+			r, c := row+dr, col+dc
+			if r < 0 || c < 0 || r >= q.size || c >= q.size {
+				continue
+			}
+			dist := max(abs(dr), abs(dc))
+			q.set(r, c, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (q *symbol) drawAlignment(row, col int) {
+	for dr := -2; dr <= 2; dr++ {
+		for dc := -2; dc <= 2; dc++ {
+			q.set(row+dr, col+dc, max(abs(dr), abs(dc)) != 1)
+		}
+	}
+}
+
+// formatBits returns the BCH encoded format information of an error correction level and mask.
+func formatBits(level, mask int) int {
+	data := level<<3 | mask
+
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionBits returns the BCH encoded version information.
+func versionBits(version int) int {
+	rem := version
+	for range 12 {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+
+	return version<<12 | rem
+}
+
+func (q *symbol) drawFormatBits(mask int) {
+	bits := formatBits(levelM, mask)
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := range 6 {
+		q.set(i, 8, bit(i))
+	}
+	q.set(7, 8, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(8, 14-i, bit(i))
+	}
+
+	for i := range 8 {
+		q.set(8, q.size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(q.size-15+i, 8, bit(i))
+	}
+
+	// The dark module.
+	q.set(q.size-8, 8, true)
+}
+
+func (q *symbol) drawVersion() {
+	if q.version < 7 {
+		return
+	}
+
+	bits := versionBits(q.version)
+	for i := range 18 {
+		dark := bits>>i&1 == 1
+		a, b := q.size-11+i%3, i/3
+		q.set(b, a, dark)
+		q.set(a, b, dark)
+	}
+}
+
+// drawCodewords places the codewords in the zigzag order, skipping the function patterns.
+func (q *symbol) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range q.size {
+			for j := range 2 {
+				col := right - j
+				row := vert
+				if (right+1)&2 == 0 {
+					row = q.size - 1 - vert
+				}
+				if !q.function[row][col] && i < len(data)*8 {
+					q.modules[row][col] = data[i/8]>>(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by the mask. Applying the same mask twice undoes it.
+func (q *symbol) applyMask(mask int) {
+	for r := range q.size {
+		for c := range q.size {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (r+c)%2 == 0
+			case 1:
+				flip = r%2 == 0
+			case 2:
+				flip = c%3 == 0
+			case 3:
+				flip = (r+c)%3 == 0
+			case 4:
+				flip = (r/2+c/3)%2 == 0
+			case 5:
+				flip = r*c%2+r*c%3 == 0
+			case 6:
+				flip = (r*c%2+r*c%3)%2 == 0
+			case 7:
+				flip = ((r+c)%2+r*c%3)%2 == 0
+			}
+			if flip && !q.function[r][c] {
+				q.modules[r][c] = !q.modules[r][c]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol by the four mask evaluation rules. Lower is better.
+func (q *symbol) penalty() int {
+	var p, dark int
+
+	finder := []bool{true, false, true, true, true, false, true}
+
+	for i := range q.size {
+		row := make([]bool, q.size)
+		col := make([]bool, q.size)
+		for j := range q.size {
+			row[j] = q.modules[i][j]
+			col[j] = q.modules[j][i]
+			if row[j] {
+				dark++
+			}
+		}
+		p += runPenalty(row) + runPenalty(col)
+		p += finderPenalty(row, finder) + finderPenalty(col, finder)
+	}
+
+	for r := range q.size - 1 {
+		for c := range q.size - 1 {
+			m := q.modules[r][c]
+			if m == q.modules[r][c+1] && m == q.modules[r+1][c] && m == q.modules[r+1][c+1] {
+				p += 3
+			}
+		}
+	}
+
+	total := q.size * q.size
+	p += abs(dark*100/total-50) / 5 * 10
+
+	return p
+}
+
+// runPenalty scores runs of five or more modules of the same color.
+func runPenalty(line []bool) int {
+	var p int
+
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			p += run - 2
+		}
+		run = 1
+	}
+
+	return p
+}
+
+// finderPenalty scores finder-like patterns preceded or followed by four light modules.
+func finderPenalty(line, finder []bool) int {
+	var p int
+
+	light := func(from, to int) bool {
+		for i := from; i < to; i++ {
+			if i >= 0 && i < len(line) && line[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := 0; i+len(finder) <= len(line); i++ {
+		match := true
+		for j, f := range finder {
+			if line[i+j] != f {
+				match = false
+				break
+			}
+		}
+		if match && (light(i-4, i) || light(i+len(finder), i+len(finder)+4)) {
+			p += 40
+		}
+	}
+
+	return p
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,24 @@
+package cryptobot
+
+import "net/url"
+
+// redactedPrefix is the number of leading characters left visible by redact.
+const redactedPrefix = 4
+
+// redact hides everything but the beginning of a secret-like value.
+func redact(s string) string {
+	r := []rune(s)
+	if len(r) <= redactedPrefix {
+		return s
+	}
+	return string(r[:redactedPrefix]) + "…"
+}
+
+// redactURL hides everything but the scheme and host of a URL.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || len(u.Host) == 0 {
+		return redact(s)
+	}
+	return u.Scheme + "://" + u.Host + "/…"
+}
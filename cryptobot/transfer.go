@@ -1,8 +1,8 @@
 package cryptobot
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -27,7 +27,7 @@ type Transfer struct {
 	CryptoAsset CryptoAsset `json:"asset"`
 
 	// Amount of the transfer.
-	Amount string `json:"amount"`
+	Amount Amount `json:"amount"`
 
 	// Transfer status.
 	Status TransferStatus `json:"status"`
@@ -39,6 +39,16 @@ type Transfer struct {
 	Comment string `json:"comment,omitempty"`
 }
 
+// String formats the transfer with its spend id redacted, since it is the idempotency key of the payout.
+func (t Transfer) String() string {
+	type transfer Transfer
+
+	r := transfer(t)
+	r.SpendID = redact(r.SpendID)
+
+	return fmt.Sprintf("%+v", r)
+}
+
 type NewTransfer struct {
 	// Telegram user id the transfer will be sent to.
 	UserID int64 `json:"user_id"`
@@ -55,8 +65,8 @@ type NewTransfer struct {
 	// Optional. Comment for the transfer. Users will see this comment in the notification about the transfer. 1024 characters max.
 	Comment string `json:"comment,omitempty"`
 
-	// Optional. Weither or not to notify the user about the transfer. Defaults to false.
-	DisableSendNotification bool `json:"disable_send_notification"`
+	// Optional. Weither or not to disable the notification about the transfer. Left to the API default when nil.
+	DisableSendNotification *bool `json:"disable_send_notification,omitempty"`
 }
 
 type TransferOptions struct {
@@ -85,13 +95,13 @@ type tempTrOps struct {
 }
 
 func (to TransferOptions) MarshalJSON() ([]byte, error) {
-	ids := make([]string, len(to.TransferIDs))
+	ids := make([]string, 0, len(to.TransferIDs))
 
 	for _, id := range to.TransferIDs {
 		ids = append(ids, strconv.FormatInt(id, 10))
 	}
 
-	return json.Marshal(&tempTrOps{
+	return marshal(&tempTrOps{
 		CryptoAsset: string(to.CryptoAsset),
 		TransferIDs: strings.Join(ids, ","),
 		SpendID:     to.SpendID,
@@ -142,3 +152,31 @@ func validateTransferOptions(trops TransferOptions) error {
 
 	return errors.Join(errs...)
 }
+
+// validateNewTransfers validates every transfer of a batch and reports the spend ids used by more than one of them.
+func validateNewTransfers(nts []NewTransfer) error {
+	var errs []error
+
+	seen := make(map[string]int, len(nts))
+
+	for i, nt := range nts {
+		if err := validateNewTransfer(nt); err != nil {
+			errs = append(errs, fmt.Errorf("transfer %d: %w", i, err))
+		}
+
+		if len(nt.SpendID) == 0 {
+			continue
+		}
+		if j, ok := seen[nt.SpendID]; ok {
+			errs = append(errs, fmt.Errorf("transfers %d and %d share the SpendID %q", j, i, redact(nt.SpendID)))
+			continue
+		}
+		seen[nt.SpendID] = i
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,9 +1,17 @@
 package cryptobot
 
-type updateType string
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// UpdateType is the type of a webhook update.
+type UpdateType string
 
 const (
-	updateInvoicePaid = "invoice_paid"
+	// UpdateInvoicePaid is sent when an invoice is paid.
+	UpdateInvoicePaid UpdateType = "invoice_paid"
 )
 
 type Update struct {
@@ -11,9 +19,16 @@ type Update struct {
 	ID int64 `json:"update_id"`
 
 	// Webhook update type.
-	Type updateType `json:"update_type"`
+	Type UpdateType `json:"update_type"`
 
 	// Date the request was sent (ISO 8601 format).
 	RequestDate string  `json:"request_date"`
 	Payload     Invoice `json:"payload"`
 }
+
+// DedupKey returns a stable key identifying the update, since its ID alone is not unique.
+// The same update delivered more than once has the same key, so it can be stored to drop duplicates.
+func (u Update) DedupKey() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s", u.ID, u.Payload.ID, u.RequestDate)))
+	return hex.EncodeToString(sum[:])
+}
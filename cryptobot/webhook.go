@@ -0,0 +1,133 @@
+package cryptobot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// WebhookAppHeader is the request header used by WebhookMux to select the application.
+const WebhookAppHeader = "X-Cryptobot-App"
+
+type webhookApp struct {
+	client  Client
+	handler func(u Update)
+}
+
+// WebhookMux serves webhook updates of several applications behind one endpoint. Every application
+// is registered under an identifier, which is taken from the WebhookAppHeader header or, when the header is absent,
+// from the last segment of the request path (e.g. /webhook/<app>). The update is verified only against the token
+// of the selected application. Unknown applications and failed verifications get the same response, so a caller
+// cannot tell them apart.
+type WebhookMux struct {
+	mu   sync.RWMutex
+	apps map[string]webhookApp
+}
+
+// NewWebhookMux creates an empty webhook multiplexer.
+func NewWebhookMux() *WebhookMux {
+	return &WebhookMux{apps: make(map[string]webhookApp)}
+}
+
+// Handle registers the client of an application and the handler its verified updates are dispatched to.
+// Like http.ServeMux, it panics on an empty application, a nil client or handler, and an application
+// that is already registered.
+func (m *WebhookMux) Handle(app string, c Client, h func(u Update)) {
+	if len(app) == 0 {
+		panic("cryptobot: empty webhook application")
+	}
+	if c == nil {
+		panic("cryptobot: nil client for webhook application " + app)
+	}
+	if h == nil {
+		panic("cryptobot: nil handler for webhook application " + app)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apps[app]; ok {
+		panic("cryptobot: multiple registrations for webhook application " + app)
+	}
+	m.apps[app] = webhookApp{client: c, handler: h}
+}
+
+func (m *WebhookMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app := r.Header.Get(WebhookAppHeader)
+	if len(app) == 0 {
+		app = path.Base(r.URL.Path)
+	}
+
+	m.mu.RLock()
+	a, ok := m.apps[app]
+	m.mu.RUnlock()
+
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.client.HandleUpdate(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	a.handler(u)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type updateKey struct{}
+
+// UpdateFromContext returns the update stored in the context by VerifySignatureMiddleware.
+func UpdateFromContext(ctx context.Context) (Update, bool) {
+	u, ok := ctx.Value(updateKey{}).(Update)
+	return u, ok
+}
+
+// VerifySignatureMiddleware returns a middleware that verifies webhook updates against the token of an application.
+// Verified updates are passed on in the request context (see UpdateFromContext) together with the unread body,
+// while requests that fail the verification are answered with 401 and not passed on. It can be chained with any
+// other http.Handler middleware.
+func VerifySignatureMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+
+			u, err := verifyUpdate(token, r.Header.Get("crypto-pay-api-signature"), body)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), updateKey{}, u))
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// UpdateHandler returns a handler that dispatches the update verified by VerifySignatureMiddleware to h
+// and responds with 200. Requests without a verified update are answered with 401.
+func UpdateHandler(h func(u Update)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := UpdateFromContext(r.Context())
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		h(u)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}